@@ -0,0 +1,479 @@
+package solaxx1rs485
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidRule    = errors.New("Invalid alert rule")
+	ErrAlertQueueFull = errors.New("Alert queue is full")
+)
+
+/*
+Rule is an alerting rule, evaluated for every sample of every inverter.
+
+Exactly one condition must be set:
+
+	When	A threshold on a numeric field of NormalizedNormalInfoResponse: "<field> <op> <value>",
+		with op one of < <= > >= == !=, e.g. "Temperature > 70" or "Power <= 0"
+	Fault	A fault name (see FaultCode), or "any" for any fault of at least Severity
+	Mode	A mode name (e.g. "Fault") to alert while the inverter is in that mode,
+		or "change" to alert on every mode change
+
+An alert is sent when the condition has held for For, and a resolved alert when
+it no longer holds. Mode changes are sent immediately and are never resolved.
+*/
+type Rule struct {
+	Name     string
+	When     string        `json:",omitempty"`
+	Fault    string        `json:",omitempty"`
+	Severity string        `json:",omitempty"` // Minimum severity (warning, error, critical) for Fault "any"
+	Mode     string        `json:",omitempty"`
+	For      time.Duration `json:"-"`          // How long the condition must hold before alerting
+	Between  string        `json:",omitempty"` // Only evaluate between these local times, e.g. "11:00-14:00"
+	Repeat   time.Duration `json:"-"`          // Send the alert again while the condition holds, 0 sends it once
+	Notify   []string      `json:",omitempty"` // Names of the notifiers to use, all notifiers when empty
+
+	field     string
+	op        string
+	threshold float64
+	fault     FaultCode
+	severity  FaultSeverity
+	from, to  int // minutes since midnight
+}
+
+type ruleJSON Rule
+
+// UnmarshalJSON reads For and Repeat as durations, e.g. "15m"
+func (r *Rule) UnmarshalJSON(body []byte) error {
+	var in struct {
+		ruleJSON
+		For    string
+		Repeat string
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		return err
+	}
+	*r = Rule(in.ruleJSON)
+	for _, d := range []struct {
+		s   string
+		dst *time.Duration
+	}{{in.For, &r.For}, {in.Repeat, &r.Repeat}} {
+		if d.s == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.s)
+		if err != nil {
+			return fmt.Errorf("%w %q: %s", ErrInvalidRule, r.Name, err)
+		}
+		*d.dst = v
+	}
+	return nil
+}
+
+func (r Rule) MarshalJSON() ([]byte, error) {
+	out := struct {
+		ruleJSON
+		For    string `json:",omitempty"`
+		Repeat string `json:",omitempty"`
+	}{ruleJSON: ruleJSON(r)}
+	if r.For > 0 {
+		out.For = r.For.String()
+	}
+	if r.Repeat > 0 {
+		out.Repeat = r.Repeat.String()
+	}
+	return json.Marshal(out)
+}
+
+// compile validates the rule and parses its condition
+func (r *Rule) compile() error {
+	conditions := 0
+	for _, c := range []string{r.When, r.Fault, r.Mode} {
+		if c != "" {
+			conditions++
+		}
+	}
+	if conditions != 1 {
+		return fmt.Errorf("%w %q: exactly one of When, Fault or Mode must be set", ErrInvalidRule, r.Name)
+	}
+
+	switch {
+	case r.When != "":
+		parts := strings.Fields(r.When)
+		if len(parts) != 3 {
+			return fmt.Errorf("%w %q: expected \"<field> <op> <value>\", got %q", ErrInvalidRule, r.Name, r.When)
+		}
+		if _, err := fieldValue(NormalizedNormalInfoResponse{}, parts[0]); err != nil {
+			return fmt.Errorf("%w %q: %s", ErrInvalidRule, r.Name, err)
+		}
+		for _, f := range (NormalizedNormalInfoResponse{}).Fields() {
+			if strings.EqualFold(f.Name, parts[0]) {
+				parts[0] = f.Name // as spelled in the alert messages
+			}
+		}
+		switch parts[1] {
+		case "<", "<=", ">", ">=", "==", "!=":
+		default:
+			return fmt.Errorf("%w %q: unknown operator %q", ErrInvalidRule, r.Name, parts[1])
+		}
+		threshold, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return fmt.Errorf("%w %q: invalid value %q", ErrInvalidRule, r.Name, parts[2])
+		}
+		r.field, r.op, r.threshold = parts[0], parts[1], threshold
+	case r.Fault != "":
+		if !strings.EqualFold(r.Fault, "any") {
+			for _, f := range AllFaults() {
+				if strings.EqualFold(f.String(), r.Fault) {
+					r.fault = f
+				}
+			}
+			if r.fault == 0 {
+				return fmt.Errorf("%w %q: unknown fault %q", ErrInvalidRule, r.Name, r.Fault)
+			}
+		}
+		r.severity = FaultWarning
+		if r.Severity != "" {
+			r.severity = 0
+			for _, s := range []FaultSeverity{FaultWarning, FaultError, FaultCritical} {
+				if strings.EqualFold(s.String(), r.Severity) {
+					r.severity = s
+				}
+			}
+			if r.severity == 0 {
+				return fmt.Errorf("%w %q: unknown severity %q", ErrInvalidRule, r.Name, r.Severity)
+			}
+		}
+	case !strings.EqualFold(r.Mode, "change"):
+		known := false
+		for mode := uint16(0); mode <= 6; mode++ {
+			known = known || strings.EqualFold(ModeName(mode), r.Mode)
+		}
+		if !known {
+			return fmt.Errorf("%w %q: unknown mode %q", ErrInvalidRule, r.Name, r.Mode)
+		}
+	}
+
+	r.from, r.to = 0, 24*60
+	if r.Between != "" {
+		from, to, ok := strings.Cut(r.Between, "-")
+		var err1, err2 error
+		r.from, err1 = minutesOfDay(from)
+		r.to, err2 = minutesOfDay(to)
+		if !ok || err1 != nil || err2 != nil {
+			return fmt.Errorf("%w %q: expected Between as \"HH:MM-HH:MM\", got %q", ErrInvalidRule, r.Name, r.Between)
+		}
+	}
+	return nil
+}
+
+// active reports whether t is in the Between window, which may wrap around midnight
+func (r *Rule) active(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if r.from <= r.to {
+		return m >= r.from && m < r.to
+	}
+	return m >= r.from || m < r.to
+}
+
+// condition returns whether the condition holds for info and a description of the current value
+func (r *Rule) condition(info NormalInfoResponse) (bool, string) {
+	switch {
+	case r.When != "":
+		v, _ := fieldValue(NormalizeInfoResponse(info), r.field)
+		holds := map[string]bool{"<": v < r.threshold, "<=": v <= r.threshold, ">": v > r.threshold, ">=": v >= r.threshold, "==": v == r.threshold, "!=": v != r.threshold}[r.op]
+		return holds, fmt.Sprintf("%s is %s", r.field, strconv.FormatFloat(v, 'f', -1, 64))
+	case r.Fault != "":
+		matching := FaultCode(0)
+		for _, f := range info.Faults().List() {
+			if (r.fault == 0 || f == r.fault) && f.Severity() >= r.severity {
+				matching |= f
+			}
+		}
+		return matching != 0, "faults: " + matching.String()
+	default:
+		return strings.EqualFold(ModeName(info.Mode), r.Mode), "mode is " + ModeName(info.Mode)
+	}
+}
+
+func minutesOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Alert is a notification of a rule that started or stopped firing for an inverter
+type Alert struct {
+	Rule     string
+	Address  byte
+	Time     time.Time
+	Message  string
+	Resolved bool
+}
+
+func (a Alert) String() string {
+	return a.Message
+}
+
+// AlertConfig is the configuration of an AlertEngine, typically read from a JSON file with LoadAlertConfig
+type AlertConfig struct {
+	Rules     []Rule
+	Notifiers []NotifierConfig
+}
+
+// LoadAlertConfig reads an AlertConfig from the JSON file at path
+func LoadAlertConfig(path string) (AlertConfig, error) {
+	var config AlertConfig
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(body, &config); err != nil {
+		return config, fmt.Errorf("reading alert config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+/*
+AlertEngine evaluates rules for every sample and dispatches alerts to notifiers.
+
+Failed samples are not evaluated, so a rule like "Power <= 0" does not fire
+when the inverter can't be reached.
+*/
+type AlertEngine struct {
+	Rules     []Rule
+	Notifiers map[string]Notifier
+	Location  *time.Location // Location of the Between windows, defaults to local time
+
+	mu    sync.Mutex
+	state map[alertKey]*alertState
+	modes map[byte]uint16
+}
+
+type alertKey struct {
+	rule    int
+	address byte
+}
+
+type alertState struct {
+	since  time.Time // when the condition started to hold
+	fired  bool
+	notify time.Time // when the alert was last sent
+}
+
+// NewAlertEngine validates the rules and creates the notifiers of config
+func NewAlertEngine(config AlertConfig) (*AlertEngine, error) {
+	e := &AlertEngine{Notifiers: map[string]Notifier{}, Location: time.Local}
+	for i, nc := range config.Notifiers {
+		n, err := NewNotifier(nc)
+		if err != nil {
+			return nil, err
+		}
+		name := nc.Name
+		if name == "" {
+			name = fmt.Sprintf("%s%d", nc.Type, i)
+		}
+		e.Notifiers[name] = n
+	}
+	names := map[string]bool{}
+	for _, r := range config.Rules {
+		if err := r.compile(); err != nil {
+			return nil, err
+		}
+		// alerts refer to their rule by name
+		if names[r.Name] {
+			return nil, fmt.Errorf("%w %q: duplicate name", ErrInvalidRule, r.Name)
+		}
+		names[r.Name] = true
+		for _, name := range r.Notify {
+			if _, ok := e.Notifiers[name]; !ok {
+				return nil, fmt.Errorf("%w %q: unknown notifier %q", ErrInvalidRule, r.Name, name)
+			}
+		}
+		e.Rules = append(e.Rules, r)
+	}
+	return e, nil
+}
+
+// Evaluate updates the rules with a sample and returns the alerts to send
+func (e *AlertEngine) Evaluate(s Sample) []Alert {
+	if s.Err != nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.state == nil {
+		e.state = map[alertKey]*alertState{}
+		e.modes = map[byte]uint16{}
+	}
+	address := s.Inverter.Address
+	previousMode, knownMode := e.modes[address]
+	e.modes[address] = s.Info.Mode
+	local := s.Time.In(e.location())
+
+	alerts := []Alert{}
+	for i := range e.Rules {
+		r := &e.Rules[i]
+		alert := func(resolved bool, format string, args ...interface{}) {
+			alerts = append(alerts, Alert{Rule: r.Name, Address: address, Time: s.Time, Resolved: resolved, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if strings.EqualFold(r.Mode, "change") {
+			if knownMode && previousMode != s.Info.Mode && r.active(local) {
+				alert(false, "%s: inverter %d changed from mode %s to %s", r.Name, address, ModeName(previousMode), ModeName(s.Info.Mode))
+			}
+			continue
+		}
+
+		k := alertKey{i, address}
+		st, ok := e.state[k]
+		if !ok {
+			st = &alertState{}
+			e.state[k] = st
+		}
+		holds, value := r.condition(*s.Info)
+		if !holds || !r.active(local) {
+			if st.fired {
+				alert(true, "%s resolved: inverter %d, %s", r.Name, address, value)
+			}
+			*st = alertState{}
+			continue
+		}
+		if st.since.IsZero() {
+			st.since = s.Time
+		}
+		held := s.Time.Sub(st.since)
+		if held < r.For {
+			continue
+		}
+		if !st.fired || (r.Repeat > 0 && s.Time.Sub(st.notify) >= r.Repeat) {
+			st.fired, st.notify = true, s.Time
+			if held > 0 {
+				value += " for " + held.Round(time.Second).String()
+			}
+			alert(false, "%s: inverter %d, %s", r.Name, address, value)
+		}
+	}
+	return alerts
+}
+
+// Handle evaluates a sample and sends the resulting alerts, see Notify. Use an AlertQueue to send them in the background.
+func (e *AlertEngine) Handle(ctx context.Context, s Sample) ([]Alert, error) {
+	alerts := e.Evaluate(s)
+	var firstErr error
+	for _, a := range alerts {
+		if err := e.Notify(ctx, a); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return alerts, firstErr
+}
+
+// Notify sends an alert to the notifiers of its rule, all notifiers are tried and the first error is returned
+func (e *AlertEngine) Notify(ctx context.Context, a Alert) error {
+	names := []string{}
+	for _, r := range e.Rules {
+		if r.Name == a.Rule {
+			names = r.Notify
+		}
+	}
+	if len(names) == 0 {
+		for name := range e.Notifiers {
+			names = append(names, name)
+		}
+	}
+	var firstErr error
+	for _, name := range names {
+		if err := e.Notifiers[name].Notify(ctx, a); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("notifier %s: %w", name, err)
+		}
+	}
+	return firstErr
+}
+
+/*
+AlertQueue evaluates samples with an AlertEngine and sends the alerts in the
+background, so a slow notifier (e.g. a webhook timing out) doesn't delay polling.
+
+Alerts are sent one at a time in the order they were raised. When Size alerts
+are already waiting, new alerts are dropped and reported to onError with
+ErrAlertQueueFull.
+*/
+type AlertQueue struct {
+	Engine *AlertEngine
+
+	alerts  chan Alert
+	onError func(Alert, error)
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewAlertQueue starts sending alerts of engine, with up to size alerts waiting. onError is called for alerts that failed to send.
+func NewAlertQueue(engine *AlertEngine, size int, onError func(Alert, error)) *AlertQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &AlertQueue{Engine: engine, alerts: make(chan Alert, size), onError: onError, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+// Handle evaluates a sample and queues the resulting alerts
+func (q *AlertQueue) Handle(s Sample) []Alert {
+	alerts := q.Engine.Evaluate(s)
+	for _, a := range alerts {
+		select {
+		case q.alerts <- a:
+		default:
+			q.failed(a, ErrAlertQueueFull)
+		}
+	}
+	return alerts
+}
+
+// Close stops accepting alerts and waits until the queued alerts are sent, or cancels sending them when ctx is done.
+// Handle must not be called after Close.
+func (q *AlertQueue) Close(ctx context.Context) error {
+	close(q.alerts)
+	defer q.cancel()
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-q.done
+		return ctx.Err()
+	}
+}
+
+func (q *AlertQueue) run() {
+	defer close(q.done)
+	for a := range q.alerts {
+		if err := q.Engine.Notify(q.ctx, a); err != nil {
+			q.failed(a, err)
+		}
+	}
+}
+
+func (q *AlertQueue) failed(a Alert, err error) {
+	if q.onError != nil {
+		q.onError(a, err)
+	}
+}
+
+func (e *AlertEngine) location() *time.Location {
+	if e.Location == nil {
+		return time.Local
+	}
+	return e.Location
+}
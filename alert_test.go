@@ -0,0 +1,160 @@
+package solaxx1rs485
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertEngine(t *testing.T) {
+	var out strings.Builder
+	engine, err := NewAlertEngine(AlertConfig{Rules: []Rule{
+		{Name: "No power", When: "Power <= 0", Between: "11:00-14:00", For: 10 * time.Minute},
+		{Name: "Hot", When: "temperature > 70", Repeat: time.Hour},
+		{Name: "Fault", Fault: "any", Severity: "error"},
+		{Name: "Mode", Mode: "change"},
+	}})
+	require.NoError(t, err)
+	engine.Location = time.UTC
+	engine.Notifiers["out"] = NewWriterNotifier(&out)
+
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	evaluate := func(minutes int, info NormalInfoResponse) []string {
+		alerts, err := engine.Handle(context.Background(), Sample{Time: start.Add(time.Duration(minutes) * time.Minute), Inverter: Inverter{Address: 1}, Info: &info})
+		require.NoError(t, err)
+		messages := []string{}
+		for _, a := range alerts {
+			messages = append(messages, a.Message)
+		}
+		return messages
+	}
+
+	require.Empty(t, evaluate(0, NormalInfoResponse{Mode: ModeNormal, Power: 0, Temperature: 40}))
+	require.Empty(t, evaluate(5, NormalInfoResponse{Mode: ModeNormal, Power: 0, Temperature: 40}))
+	require.Equal(t, []string{"No power: inverter 1, Power is 0 for 10m0s"}, evaluate(10, NormalInfoResponse{Mode: ModeNormal, Power: 0, Temperature: 40}))
+	require.Empty(t, evaluate(15, NormalInfoResponse{Mode: ModeNormal, Power: 0, Temperature: 40}))
+	require.Equal(t, []string{"No power resolved: inverter 1, Power is 800"}, evaluate(20, NormalInfoResponse{Mode: ModeNormal, Power: 800, Temperature: 40}))
+
+	// repeated every hour while it holds
+	require.Equal(t, []string{"Hot: inverter 1, Temperature is 75"}, evaluate(21, NormalInfoResponse{Mode: ModeNormal, Power: 800, Temperature: 75}))
+	require.Empty(t, evaluate(50, NormalInfoResponse{Mode: ModeNormal, Power: 800, Temperature: 75}))
+	require.Equal(t, []string{"Hot: inverter 1, Temperature is 75 for 1h0m0s"}, evaluate(81, NormalInfoResponse{Mode: ModeNormal, Power: 800, Temperature: 75}))
+
+	// warnings are below the severity of the fault rule
+	require.Equal(t, []string{"Hot resolved: inverter 1, Temperature is 40", "Mode: inverter 1 changed from mode Normal to Fault"},
+		evaluate(90, NormalInfoResponse{Mode: 3, Power: 800, Temperature: 40, ErrMessage: uint32(FaultGridVolt)}))
+	require.Equal(t, []string{"Fault: inverter 1, faults: EepromFault"},
+		evaluate(91, NormalInfoResponse{Mode: 3, Power: 800, Temperature: 40, ErrMessage: uint32(FaultGridVolt | FaultEeprom)}))
+
+	// failed samples are not evaluated
+	alerts, err := engine.Handle(context.Background(), Sample{Time: start.Add(92 * time.Minute), Inverter: Inverter{Address: 1}, Err: ErrNoInverter})
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+
+	require.Equal(t, []string{"Fault resolved: inverter 1, faults: None", "Mode: inverter 1 changed from mode Fault to Normal"},
+		evaluate(100, NormalInfoResponse{Mode: ModeNormal}))
+	require.Equal(t, []string{"No power: inverter 1, Power is 0 for 10m0s"}, evaluate(110, NormalInfoResponse{Mode: ModeNormal}))
+
+	// rules resolve outside of their window
+	require.Equal(t, []string{"No power resolved: inverter 1, Power is 0"}, evaluate(125, NormalInfoResponse{Mode: ModeNormal}))
+
+	require.Contains(t, out.String(), "2022-06-01 ")
+	require.Contains(t, out.String(), "Hot: inverter 1, Temperature is 75 for 1h0m0s\n")
+}
+
+func TestAlertConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"Rules": [{"Name": "No power", "When": "Power <= 0", "For": "15m", "Notify": ["log"]}],
+		"Notifiers": [{"Name": "log", "Type": "stdout"}, {"Type": "webhook", "URL": "http://localhost/hook"}]
+	}`), 0o644))
+	config, err := LoadAlertConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, 15*time.Minute, config.Rules[0].For)
+
+	engine, err := NewAlertEngine(config)
+	require.NoError(t, err)
+	require.Len(t, engine.Notifiers, 2)
+	require.Contains(t, engine.Notifiers, "webhook1")
+
+	out, err := json.Marshal(config.Rules[0])
+	require.NoError(t, err)
+	require.Contains(t, string(out), `"For":"15m0s"`)
+	require.NotContains(t, string(out), "Repeat")
+
+	for _, r := range []Rule{
+		{Name: "none"},
+		{Name: "both", When: "Power > 0", Mode: "Fault"},
+		{Name: "field", When: "Foo > 1"},
+		{Name: "op", When: "Power ~ 1"},
+		{Name: "fault", Fault: "Foo"},
+		{Name: "mode", Mode: "Foo"},
+		{Name: "between", When: "Power > 0", Between: "11-14"},
+		{Name: "notifier", When: "Power > 0", Notify: []string{"foo"}},
+	} {
+		_, err := NewAlertEngine(AlertConfig{Rules: []Rule{r}})
+		require.ErrorIs(t, err, ErrInvalidRule, r.Name)
+	}
+	_, err = NewAlertEngine(AlertConfig{Rules: []Rule{{Name: "Hot", When: "Temperature > 70"}, {Name: "Hot", When: "Temperature > 80"}}})
+	require.ErrorIs(t, err, ErrInvalidRule)
+	_, err = NewAlertEngine(AlertConfig{Notifiers: []NotifierConfig{{Type: "pager"}}})
+	require.ErrorIs(t, err, ErrInvalidNotifier)
+}
+
+// blockingNotifier blocks every Notify until release is closed
+type blockingNotifier struct {
+	release chan struct{}
+	mu      sync.Mutex
+	alerts  []Alert
+}
+
+func (n *blockingNotifier) Notify(ctx context.Context, a Alert) error {
+	select {
+	case <-n.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, a)
+	return nil
+}
+
+func TestAlertQueue(t *testing.T) {
+	engine, err := NewAlertEngine(AlertConfig{Rules: []Rule{{Name: "Hot", When: "Temperature > 70", Repeat: time.Minute}}})
+	require.NoError(t, err)
+	notifier := &blockingNotifier{release: make(chan struct{})}
+	engine.Notifiers["slow"] = notifier
+
+	var mu sync.Mutex
+	failed := []error{}
+	q := NewAlertQueue(engine, 1, func(a Alert, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, err)
+	})
+
+	// Handle returns while the notifier blocks, alerts beyond the queue size are dropped
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	sent := 0
+	for i := 0; i < 4; i++ {
+		sent += len(q.Handle(Sample{Time: start.Add(time.Duration(i) * time.Minute), Inverter: Inverter{Address: 1}, Info: &NormalInfoResponse{Temperature: 75}}))
+	}
+	require.Equal(t, 4, sent)
+	mu.Lock()
+	require.NotEmpty(t, failed)
+	require.ErrorIs(t, failed[0], ErrAlertQueueFull)
+	dropped := len(failed)
+	mu.Unlock()
+
+	close(notifier.release)
+	require.NoError(t, q.Close(context.Background()))
+	require.Len(t, notifier.alerts, 4-dropped)
+}
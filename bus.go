@@ -0,0 +1,257 @@
+package solaxx1rs485
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownInverter = errors.New("Inverter not found in registry")
+	ErrNoFreeAddress   = errors.New("No free address left on the bus")
+)
+
+// KnownInverter is an inverter in the Bus registry
+type KnownInverter struct {
+	Serial          []byte // Serial used for registration, stored as hex
+	Address         byte
+	SerialNumber    string // Serial number as reported by GetInverterInfo
+	Model           string // Module name as reported by GetInverterInfo
+	FirmwareVersion string
+}
+
+/*
+Bus manages the inverters on a single RS485 bus.
+
+It keeps a registry of known inverters (serial, address and model), assigns
+free addresses to newly found inverters and persists the registry as JSON,
+so inverters can be looked up by serial instead of address.
+*/
+type Bus struct {
+	Client *Client
+	Path   string // Location of the registry file, the registry is not persisted when empty
+
+	mu        sync.Mutex
+	inverters []KnownInverter
+}
+
+// NewBus returns a Bus for client, loading the registry from path when the file exists
+func NewBus(client *Client, path string) (*Bus, error) {
+	b := &Bus{Client: client, Path: path}
+	if path == "" {
+		return b, nil
+	}
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &b.inverters); err != nil {
+		return nil, fmt.Errorf("reading registry %s: %w", path, err)
+	}
+	return b, nil
+}
+
+// Inverters returns all known inverters, ordered by address
+func (b *Bus) Inverters() []KnownInverter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]KnownInverter{}, b.inverters...)
+}
+
+// Lookup returns the known inverter with the given serial. Both the hex encoded
+// registration serial and the serial number reported by GetInverterInfo are accepted.
+func (b *Bus) Lookup(serial string) (KnownInverter, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	serial = strings.TrimSpace(serial)
+	for _, inv := range b.inverters {
+		if strings.EqualFold(hex.EncodeToString(inv.Serial), serial) || strings.EqualFold(strings.TrimSpace(inv.SerialNumber), serial) {
+			return inv, nil
+		}
+	}
+	return KnownInverter{}, fmt.Errorf("%w: serial %s", ErrUnknownInverter, serial)
+}
+
+// FreeAddress returns the lowest address (1..255) that is not used by a known inverter
+func (b *Bus) FreeAddress() (byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	used := map[byte]bool{}
+	for _, inv := range b.inverters {
+		used[inv.Address] = true
+	}
+	for a := 1; a <= 255; a++ {
+		if !used[byte(a)] {
+			return byte(a), nil
+		}
+	}
+	return 0, ErrNoFreeAddress
+}
+
+// Add queries the device details of a registered inverter and stores it in the registry
+func (b *Bus) Add(ctx context.Context, inverter *Inverter) (KnownInverter, error) {
+	if inverter == nil {
+		return KnownInverter{}, fmt.Errorf("Inverter must not be nil")
+	}
+	info, err := b.Client.GetInverterInfoContext(ctx, inverter)
+	if err != nil {
+		return KnownInverter{}, err
+	}
+	known := newKnownInverter(*inverter, *info)
+
+	b.mu.Lock()
+	b.store(known)
+	b.mu.Unlock()
+	return known, b.Save()
+}
+
+// RegisterNew finds the next unregistered inverter, registers it at the lowest free address and stores it in the registry.
+// ErrNoInverter is returned when there are no unregistered inverters left.
+func (b *Bus) RegisterNew(ctx context.Context) (KnownInverter, error) {
+	inv, err := b.Client.FindUnregisteredInverterContext(ctx)
+	if err != nil {
+		return KnownInverter{}, err
+	}
+	address, err := b.FreeAddress()
+	if err != nil {
+		return KnownInverter{}, err
+	}
+	if err := b.Client.RegisterInverterContext(ctx, inv, address); err != nil {
+		return KnownInverter{}, err
+	}
+	return b.Add(ctx, inv)
+}
+
+// Commission registers all unregistered inverters on the bus at free addresses (see Client.DiscoverAndRegisterAll)
+// and stores them in the registry. The inverters registered before an error occurred are stored and returned as well.
+func (b *Bus) Commission(ctx context.Context) ([]KnownInverter, error) {
+	used := []byte{}
+	for _, inv := range b.Inverters() {
+		used = append(used, inv.Address)
+	}
+	registrations, err := b.Client.DiscoverAndRegisterAll(ctx, DiscoverOptions{Used: used})
+
+	known := []KnownInverter{}
+	b.mu.Lock()
+	for _, r := range registrations {
+		k := newKnownInverter(r.Inverter, r.Info)
+		b.store(k)
+		known = append(known, k)
+	}
+	b.mu.Unlock()
+	if saveErr := b.Save(); err == nil {
+		err = saveErr
+	}
+	return known, err
+}
+
+// Refresh queries the device details of all known inverters and updates the registry.
+// Inverters that fail to respond are kept, the first error is returned.
+func (b *Bus) Refresh(ctx context.Context) error {
+	var firstErr error
+	for _, inv := range b.Inverters() {
+		if _, err := b.Add(ctx, inv.Inverter()); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("inverter at address %d: %w", inv.Address, err)
+		}
+	}
+	return firstErr
+}
+
+// Remove deletes the inverter at address from the registry
+func (b *Bus) Remove(address byte) error {
+	b.mu.Lock()
+	for i, inv := range b.inverters {
+		if inv.Address == address {
+			b.inverters = append(b.inverters[:i], b.inverters[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+	return b.Save()
+}
+
+// Save writes the registry to Path
+func (b *Bus) Save() error {
+	if b.Path == "" {
+		return nil
+	}
+	b.mu.Lock()
+	body, err := json.MarshalIndent(b.inverters, "", "  ")
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.Path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(b.Path, body, 0o644)
+}
+
+// store adds or replaces known in the registry, an existing entry with the same serial or address is replaced
+func (b *Bus) store(known KnownInverter) {
+	inverters := []KnownInverter{known}
+	for _, inv := range b.inverters {
+		if inv.Address == known.Address || string(inv.Serial) == string(known.Serial) {
+			continue
+		}
+		inverters = append(inverters, inv)
+	}
+	sort.Slice(inverters, func(i, j int) bool { return inverters[i].Address < inverters[j].Address })
+	b.inverters = inverters
+}
+
+func newKnownInverter(inverter Inverter, info InverterInfoResponse) KnownInverter {
+	known := KnownInverter{
+		Serial:          append([]byte{}, inverter.Serial...),
+		Address:         inverter.Address,
+		SerialNumber:    strings.TrimSpace(info.SerialNumber),
+		Model:           strings.TrimSpace(info.ModuleName),
+		FirmwareVersion: strings.TrimSpace(info.FirmwareVersion),
+	}
+	if len(known.Serial) == 0 {
+		known.Serial = []byte(info.SerialNumber)
+	}
+	return known
+}
+
+// Inverter returns the inverter to use with the Client
+func (k KnownInverter) Inverter() *Inverter {
+	return &Inverter{Serial: append([]byte{}, k.Serial...), Address: k.Address}
+}
+
+type knownInverterJSON KnownInverter
+
+// MarshalJSON encodes the serial as hex, the same format as used by the CLI
+func (k KnownInverter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		knownInverterJSON
+		Serial string
+	}{knownInverterJSON(k), strings.ToUpper(hex.EncodeToString(k.Serial))})
+}
+
+func (k *KnownInverter) UnmarshalJSON(body []byte) error {
+	var in struct {
+		knownInverterJSON
+		Serial string
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		return err
+	}
+	serial, err := hex.DecodeString(in.Serial)
+	if err != nil {
+		return fmt.Errorf("invalid serial %q: %w", in.Serial, err)
+	}
+	*k = KnownInverter(in.knownInverterJSON)
+	k.Serial = serial
+	return nil
+}
@@ -0,0 +1,84 @@
+package solaxx1rs485
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testResponse(t *testing.T, controlCode, functionCode byte, data []byte) []byte {
+	p := DefaultPacket()
+	p.ControlCode = controlCode
+	p.FunctionCode = functionCode
+	p.Data = data
+	body, err := p.Bytes()
+	require.NoError(t, err)
+	return body
+}
+
+func testInverterInfo(t *testing.T, serial string) []byte {
+	data := []byte{0x01}
+	data = append(data, []byte("  3000")...)
+	data = append(data, []byte("1.20 ")...)
+	data = append(data, []byte("X1-3.0-S-D    ")...)
+	data = append(data, []byte("SolaxPower    ")...)
+	data = append(data, []byte(serial)...)
+	data = append(data, []byte("380 ")...)
+	return testResponse(t, ControlCodeRead, 0x83, data)
+}
+
+func TestBus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "solax", "registry.json")
+	serial := "XB3002I1234567"
+	conn := &mockConnection{responses: [][]byte{
+		testResponse(t, ControlCodeRegister, 0x80, []byte(serial)),
+		testResponse(t, ControlCodeRegister, 0x81, []byte{StatusACK}),
+		testInverterInfo(t, serial),
+	}}
+	c, _ := NewClientWithConnection(conn)
+	bus, err := NewBus(c, path)
+	require.NoError(t, err)
+
+	known, err := bus.RegisterNew(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, byte(1), known.Address)
+	require.Equal(t, "X1-3.0-S-D", known.Model)
+	require.Equal(t, "1.20", known.FirmwareVersion)
+	// the register request carries the first free address
+	require.Equal(t, byte(1), conn.written[1][len(conn.written[1])-3])
+
+	address, err := bus.FreeAddress()
+	require.NoError(t, err)
+	require.Equal(t, byte(2), address)
+
+	t.Run("Registry is persisted", func(t *testing.T) {
+		body, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Contains(t, string(body), `"Serial": "5842333030324931323334353637"`)
+
+		loaded, err := NewBus(c, path)
+		require.NoError(t, err)
+		require.Equal(t, bus.Inverters(), loaded.Inverters())
+
+		inv, err := loaded.Lookup("xb3002i1234567")
+		require.NoError(t, err)
+		require.Equal(t, byte(1), inv.Address)
+		inv, err = loaded.Lookup("5842333030324931323334353637")
+		require.NoError(t, err)
+		require.Equal(t, []byte(serial), inv.Inverter().Serial)
+
+		_, err = loaded.Lookup("unknown")
+		require.ErrorIs(t, err, ErrUnknownInverter)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		require.NoError(t, bus.Remove(1))
+		require.Empty(t, bus.Inverters())
+		loaded, err := NewBus(c, path)
+		require.NoError(t, err)
+		require.Empty(t, loaded.Inverters())
+	})
+}
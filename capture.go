@@ -0,0 +1,282 @@
+package solaxx1rs485
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidCapture = errors.New("Not a valid capture")
+	ErrReplayMismatch = errors.New("Request does not match the capture")
+)
+
+const captureHeader = "# solax capture v1"
+
+/*
+CaptureRecord is a chunk of bytes written to or read from the bus.
+
+A capture is a text file, so it can be attached to a bug report and read as is.
+It starts with the line "# solax capture v1", followed by one line per record:
+
+	2022-06-01T12:00:00.123456789Z > AA550000000A1102001C
+	2022-06-01T12:00:00.181234567Z < AA55000A000011823200...
+
+The time is in RFC 3339 with nanoseconds, > marks bytes written (requests) and
+< bytes read (responses), followed by the bytes in hex. Bytes are recorded as
+they were read, so a response that arrived in parts spans several records.
+Empty lines and other lines starting with # are ignored.
+*/
+type CaptureRecord struct {
+	Time time.Time
+	Sent bool // Written by the client, false for bytes read from the bus
+	Data []byte
+}
+
+func (r CaptureRecord) String() string {
+	direction := "<"
+	if r.Sent {
+		direction = ">"
+	}
+	return fmt.Sprintf("%s %s %X", r.Time.UTC().Format(time.RFC3339Nano), direction, r.Data)
+}
+
+// ParseCaptureRecord parses a single record line
+func ParseCaptureRecord(line string) (CaptureRecord, error) {
+	parts := strings.Fields(line)
+	if len(parts) != 3 || (parts[1] != ">" && parts[1] != "<") {
+		return CaptureRecord{}, fmt.Errorf("%w: expected \"<time> <direction> <hex>\", got %q", ErrInvalidCapture, line)
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return CaptureRecord{}, fmt.Errorf("%w: %s", ErrInvalidCapture, err)
+	}
+	data, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return CaptureRecord{}, fmt.Errorf("%w: %s", ErrInvalidCapture, err)
+	}
+	return CaptureRecord{Time: t, Sent: parts[1] == ">", Data: data}, nil
+}
+
+// ReadCapture reads all records of a capture
+func ReadCapture(r io.Reader) ([]CaptureRecord, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	records := []CaptureRecord{}
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if line == 1 && text != captureHeader {
+			return nil, fmt.Errorf("%w: missing header %q", ErrInvalidCapture, captureHeader)
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		record, err := ParseCaptureRecord(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// LoadCapture reads all records of the capture at path
+func LoadCapture(path string) ([]CaptureRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCapture(f)
+}
+
+/*
+CaptureConnection wraps a Connection and records all bytes written and read,
+e.g. client.Conn = NewCaptureConnection(client.Conn, file) before the first request.
+
+The capture header is written before the first record. Failing to write the
+capture doesn't affect the connection, the first error is kept for Err. w is not
+closed by Close.
+
+Flush reads the pending bytes before flushing the connection, so bytes that are
+discarded (e.g. a response that arrived after the WaitTime of the previous
+request) are in the capture as well, after a "# discarded by flush" comment.
+This waits for a read to return no data, e.g. the read timeout of the serial port.
+*/
+type CaptureConnection struct {
+	Connection
+
+	mu      sync.Mutex
+	w       io.Writer
+	started bool
+	err     error
+}
+
+func NewCaptureConnection(conn Connection, w io.Writer) *CaptureConnection {
+	return &CaptureConnection{Connection: conn, w: w}
+}
+
+// maxCaptureDrain bounds the reads of CaptureConnection.Flush, e.g. when another device keeps talking on the bus
+const maxCaptureDrain = 250 * time.Millisecond
+
+func (c *CaptureConnection) Write(p []byte) (int, error) {
+	n, err := c.Connection.Write(p)
+	if n > 0 {
+		c.record(true, p[:n], "")
+	}
+	return n, err
+}
+
+func (c *CaptureConnection) Read(p []byte) (int, error) {
+	n, err := c.Connection.Read(p)
+	if n > 0 {
+		c.record(false, p[:n], "")
+	}
+	return n, err
+}
+
+func (c *CaptureConnection) Flush() error {
+	buf := make([]byte, 256)
+	comment := "# discarded by flush"
+	for end := time.Now().Add(maxCaptureDrain); time.Now().Before(end); {
+		n, err := c.Connection.Read(buf)
+		if n > 0 {
+			c.record(false, buf[:n], comment)
+			comment = ""
+		}
+		if n == 0 || err != nil {
+			break
+		}
+	}
+	return c.Connection.Flush()
+}
+
+// Err returns the first error writing the capture
+func (c *CaptureConnection) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// record writes a record, preceded by comment when it is not empty
+func (c *CaptureConnection) record(sent bool, data []byte, comment string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	line := CaptureRecord{Time: time.Now(), Sent: sent, Data: data}.String() + "\n"
+	if comment != "" {
+		line = comment + "\n" + line
+	}
+	if !c.started {
+		line = captureHeader + "\n" + line
+		c.started = true
+	}
+	_, c.err = io.WriteString(c.w, line)
+}
+
+/*
+ReplayConnection is a Connection that plays back a capture, e.g. to reproduce a
+bug report in a unit test with NewClientWithConnection.
+
+Every Write must match the next request in the capture, otherwise it fails with
+ErrReplayMismatch. The bytes read after that request in the capture then become
+available to Read: right away, or with RealTime after the same delay as when
+they were captured (e.g. to reproduce responses arriving after the WaitTime).
+Records read before the first request are available from the start.
+*/
+type ReplayConnection struct {
+	Records  []CaptureRecord
+	RealTime bool
+
+	mu      sync.Mutex
+	next    int // index in Records of the next record to replay
+	pending []replayChunk
+}
+
+type replayChunk struct {
+	at   time.Time
+	data []byte
+}
+
+func NewReplayConnection(records []CaptureRecord) *ReplayConnection {
+	r := &ReplayConnection{Records: records}
+	r.receive(time.Now(), time.Time{})
+	return r
+}
+
+func (r *ReplayConnection) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next >= len(r.Records) {
+		return 0, fmt.Errorf("%w: end of capture, got %X", ErrReplayMismatch, p)
+	}
+	expected := r.Records[r.next]
+	if !bytes.Equal(expected.Data, p) {
+		return 0, fmt.Errorf("%w: expected %X, got %X", ErrReplayMismatch, expected.Data, p)
+	}
+	r.next++
+	r.receive(time.Now(), expected.Time)
+	return len(p), nil
+}
+
+// receive queues the records read up to the next request, sentAt is the capture time of the request they answer
+func (r *ReplayConnection) receive(now, sentAt time.Time) {
+	for ; r.next < len(r.Records) && !r.Records[r.next].Sent; r.next++ {
+		rec := r.Records[r.next]
+		at := now
+		if r.RealTime && !sentAt.IsZero() && rec.Time.After(sentAt) {
+			at = now.Add(rec.Time.Sub(sentAt))
+		}
+		r.pending = append(r.pending, replayChunk{at, append([]byte{}, rec.Data...)})
+	}
+}
+
+func (r *ReplayConnection) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	n := 0
+	for len(r.pending) > 0 && !r.pending[0].at.After(now) && n < len(p) {
+		m := copy(p[n:], r.pending[0].data)
+		n += m
+		r.pending[0].data = r.pending[0].data[m:]
+		if len(r.pending[0].data) == 0 {
+			r.pending = r.pending[1:]
+		}
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// Flush discards the bytes that are available, bytes that are still to arrive with RealTime are kept
+func (r *ReplayConnection) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for len(r.pending) > 0 && !r.pending[0].at.After(now) {
+		r.pending = r.pending[1:]
+	}
+	return nil
+}
+
+func (r *ReplayConnection) Close() error {
+	return nil
+}
+
+// Done reports whether every request in the capture has been replayed
+func (r *ReplayConnection) Done() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.next >= len(r.Records)
+}
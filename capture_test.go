@@ -0,0 +1,116 @@
+package solaxx1rs485
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureAndReplay(t *testing.T) {
+	info := testResponse(t, ControlCodeRead, 0x82, NormalInfoResponse{Power: 1500}.Data())
+	var capture bytes.Buffer
+	conn := NewCaptureConnection(&mockConnection{responses: [][]byte{info, testInverterInfo(t, "XB3002I1234567")}}, &capture)
+	c, _ := NewClientWithConnection(conn)
+	_, err := c.GetInfo(&Inverter{Address: 1})
+	require.NoError(t, err)
+	_, err = c.GetInverterInfo(&Inverter{Address: 1})
+	require.NoError(t, err)
+	require.NoError(t, conn.Err())
+
+	lines := strings.Split(strings.TrimSpace(capture.String()), "\n")
+	require.Len(t, lines, 5)
+	require.Equal(t, "# solax capture v1", lines[0])
+	require.Contains(t, lines[1], "Z > AA550000000111020001")
+	require.Contains(t, lines[2], "Z < "+fmt.Sprintf("%X", info))
+
+	records, err := ReadCapture(&capture)
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	require.True(t, records[0].Sent)
+	require.Equal(t, info, records[1].Data)
+
+	replay := NewReplayConnection(records)
+	c, _ = NewClientWithConnection(replay)
+	res, err := c.GetInfo(&Inverter{Address: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint16(1500), res.Power)
+	require.False(t, replay.Done())
+
+	_, err = c.GetConfig(&Inverter{Address: 1})
+	require.ErrorIs(t, err, ErrReplayMismatch)
+	spec, err := c.GetInverterInfo(&Inverter{Address: 1})
+	require.NoError(t, err)
+	require.Equal(t, "XB3002I1234567", spec.SerialNumber)
+	require.True(t, replay.Done())
+	_, err = c.GetInfo(&Inverter{Address: 1})
+	require.ErrorIs(t, err, ErrReplayMismatch)
+}
+
+func TestCaptureFlush(t *testing.T) {
+	info := testResponse(t, ControlCodeRead, 0x82, NormalInfoResponse{Power: 1500}.Data())
+	late := []byte{0xAA, 0x55, 0x01, 0x02}
+	mock := &mockConnection{responses: [][]byte{info}, pending: late}
+	var capture bytes.Buffer
+	c, _ := NewClientWithConnection(NewCaptureConnection(mock, &capture))
+	_, err := c.GetInfo(&Inverter{Address: 1})
+	require.NoError(t, err)
+
+	// the late bytes of a previous response are discarded by the client, but are in the capture
+	lines := strings.Split(strings.TrimSpace(capture.String()), "\n")
+	require.Len(t, lines, 5)
+	require.Equal(t, "# discarded by flush", lines[1])
+	require.Contains(t, lines[2], "Z < AA550102")
+	require.Contains(t, lines[3], "Z > ")
+
+	records, err := ReadCapture(&capture)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	c, _ = NewClientWithConnection(NewReplayConnection(records))
+	res, err := c.GetInfo(&Inverter{Address: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint16(1500), res.Power)
+}
+
+func TestReplayTiming(t *testing.T) {
+	req, err := NormalInfoRequest(1).Bytes()
+	require.NoError(t, err)
+	resp := testResponse(t, ControlCodeRead, 0x82, NormalInfoResponse{Power: 1500}.Data())
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	// the response arrived in two parts, the second one late
+	records := []CaptureRecord{
+		{Time: start, Sent: true, Data: req},
+		{Time: start.Add(20 * time.Millisecond), Data: resp[:30]},
+		{Time: start.Add(200 * time.Millisecond), Data: resp[30:]},
+	}
+
+	c, _ := NewClientWithConnection(NewReplayConnection(records))
+	info, err := c.GetInfo(&Inverter{Address: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint16(1500), info.Power)
+
+	replay := NewReplayConnection(records)
+	replay.RealTime = true
+	c, _ = NewClientWithConnection(replay)
+	c.WaitTime = 100 * time.Millisecond
+	_, err = c.GetInfoContext(context.Background(), &Inverter{Address: 1})
+	require.ErrorIs(t, err, ErrInvalidBody)
+}
+
+func TestReadCapture(t *testing.T) {
+	records, err := ReadCapture(strings.NewReader("# solax capture v1\n\n# comment\n2022-06-01T12:00:00.5Z < AA55\n"))
+	require.NoError(t, err)
+	require.Equal(t, []CaptureRecord{{Time: time.Date(2022, 6, 1, 12, 0, 0, 500000000, time.UTC), Data: []byte{0xAA, 0x55}}}, records)
+	require.Equal(t, "2022-06-01T12:00:00.5Z < AA55", records[0].String())
+
+	_, err = ReadCapture(strings.NewReader("2022-06-01T12:00:00Z < AA55\n"))
+	require.ErrorIs(t, err, ErrInvalidCapture)
+	_, err = ReadCapture(strings.NewReader("# solax capture v1\n2022-06-01T12:00:00Z <> AA55\n"))
+	require.ErrorIs(t, err, ErrInvalidCapture)
+	_, err = ReadCapture(strings.NewReader("# solax capture v1\n2022-06-01T12:00:00Z < AA5\n"))
+	require.ErrorIs(t, err, ErrInvalidCapture)
+}
@@ -1,9 +1,13 @@
 package solaxx1rs485
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tarm/serial"
@@ -20,10 +24,19 @@ type Connection interface {
 	Flush() error
 }
 
+// Client is safe for concurrent use: every request/response transaction holds the bus
+// until the response is in, so requests to different inverters on the same bus don't interleave.
 type Client struct {
-	Conn         Connection
-	LastResponse []byte
-	WaitTime     time.Duration // Time to wait for a response after sending
+	stats Stats // first field to keep the counters 64-bit aligned for atomic access on 32-bit platforms
+
+	Conn        Connection
+	WaitTime    time.Duration // Maximum time to wait for a complete response after sending
+	RetryPolicy RetryPolicy   // Retries for failed requests, by default requests are not retried
+
+	bus          sync.Mutex // held for a full Flush/Send/Read transaction
+	mu           sync.Mutex // guards lastResponse
+	lastResponse []byte
+	frames       *FrameReader
 }
 
 func NewClient(device string) (*Client, error) {
@@ -33,7 +46,8 @@ func NewClient(device string) (*Client, error) {
 	// Parity: none
 	// Stop bit: 1
 	// Example device: "/dev/tty.usbserial-A10KNFUE"
-	c := &serial.Config{Name: device, Baud: 9600, ReadTimeout: 500 * time.Millisecond}
+	// The read timeout only bounds a single read, the Client keeps reading until a full packet is in or WaitTime passed
+	c := &serial.Config{Name: device, Baud: 9600, ReadTimeout: 100 * time.Millisecond}
 	conn, err := serial.OpenPort(c)
 	if err != nil {
 		return nil, err
@@ -42,23 +56,74 @@ func NewClient(device string) (*Client, error) {
 }
 
 func NewClientWithConnection(conn Connection) (*Client, error) {
-	return &Client{Conn: conn, WaitTime: 250 * time.Millisecond}, nil
+	return &Client{Conn: conn, WaitTime: 500 * time.Millisecond, frames: NewFrameReader(conn)}, nil
 }
 
 /*
 To be implemented
 Registration:
 * [x] FindUnregisteredInverters
-* RegisterInverter
-* UnregisterInverters
+* [x] RegisterInverter
+* [x] UnregisterInverters
+* [x] ReconnectRemovedInverter
+* [x] ReregisterInverter
 Information (Read):
-* QueryInfo
-* QueryID
-* QueryConfig
-[Maybe Later] Write Config
-* WriteConfig
+* [x] QueryInfo
+* [x] QueryID
+* [x] QueryConfig
+Write Config:
+* [x] WriteConfig
+Execute:
+* [x] PowerOn / PowerOff
+* [x] Restart
+* [x] Selftest
 */
 
+// RetryPolicy determines how failed requests are retried. Only queries (control code 0x11 and the
+// query for unregistered inverters) are retried: a write, execute or registration may have been
+// applied even though its ACK got lost, so sending it again could e.g. restart the inverter twice.
+type RetryPolicy struct {
+	MaxAttempts int              // Total number of attempts per request, values below 1 mean a single attempt
+	Backoff     time.Duration    // Wait time before the first retry, doubled for every next retry
+	MaxBackoff  time.Duration    // Upper limit for the wait time between retries, 0 means no limit
+	Retryable   func(error) bool // Decides which errors are retried, defaults to IsRetryable
+}
+
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < attempt && d > 0; i++ {
+		d *= 2
+		if r.MaxBackoff > 0 && d >= r.MaxBackoff {
+			break
+		}
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
+
+// IsRetryable reports whether err is caused by a bad or missing response (e.g. a checksum mismatch on a noisy line)
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrInvalidBody) || errors.Is(err, ErrEmptyBody) || errors.Is(err, ErrNoInverter)
+}
+
+// Stats counts requests made by a Client, Retries and Failures give an indication of the line quality
+type Stats struct {
+	Requests uint64 // Requests made, excluding retries
+	Retries  uint64 // Retried attempts
+	Failures uint64 // Requests that failed after all attempts
+}
+
+// Stats returns the request counters of the client
+func (c *Client) Stats() Stats {
+	return Stats{
+		Requests: atomic.LoadUint64(&c.stats.Requests),
+		Retries:  atomic.LoadUint64(&c.stats.Retries),
+		Failures: atomic.LoadUint64(&c.stats.Failures),
+	}
+}
+
 type Inverter struct {
 	Serial  []byte
 	Address byte
@@ -73,30 +138,18 @@ type Inverter struct {
 // FindUnregisteredInverter returns the first unregistered inverter (address 0x00)
 // Use RegisterInverter afterwards to set an address for the inverter
 func (c *Client) FindUnregisteredInverter() (*Inverter, error) {
-	err := c.Conn.Flush()
-	if err != nil {
-		return nil, err
-	}
-
-	// Send the request
-	req, err := UnregisteredInverterRequest().Bytes()
-	if err != nil {
-		return nil, err
-	}
-	err = c.Send(req)
-	if err != nil {
-		return nil, err
-	}
+	return c.FindUnregisteredInverterContext(context.Background())
+}
 
-	// Get and handle the response
-	resp, err := c.Read()
-	if err != nil {
-		return nil, err
-	}
-	if len(resp) == 0 {
-		return nil, ErrNoInverter
-	}
-	r, err := ParseUnregisteredInverterResponse(resp)
+func (c *Client) FindUnregisteredInverterContext(ctx context.Context) (*Inverter, error) {
+	var r UnregisteredInverterResponse
+	err := c.do(ctx, UnregisteredInverterRequest(), func(resp []byte) (err error) {
+		if len(resp) == 0 {
+			return ErrNoInverter
+		}
+		r, err = ParseUnregisteredInverterResponse(resp)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -106,31 +159,15 @@ func (c *Client) FindUnregisteredInverter() (*Inverter, error) {
 
 // RegisterInverter Sets the bus address for an unregistered inverter
 func (c *Client) RegisterInverter(inverter *Inverter, address byte) error {
+	return c.RegisterInverterContext(context.Background(), inverter, address)
+}
+
+func (c *Client) RegisterInverterContext(ctx context.Context, inverter *Inverter, address byte) error {
 	if inverter == nil {
 		return fmt.Errorf("Inverter must not be nil")
 	}
 
-	err := c.Conn.Flush()
-	if err != nil {
-		return err
-	}
-
-	// Send the request
-	req, err := RegisterInverterRequest(inverter.Serial, address).Bytes()
-	if err != nil {
-		return err
-	}
-	err = c.Send(req)
-	if err != nil {
-		return err
-	}
-
-	// Get and handle the response
-	resp, err := c.Read()
-	if err != nil {
-		return err
-	}
-	err = ParseRegisterInverterResponse(resp)
+	err := c.do(ctx, RegisterInverterRequest(inverter.Serial, address), ParseRegisterInverterResponse)
 	if err != nil {
 		return err
 	}
@@ -141,36 +178,52 @@ func (c *Client) RegisterInverter(inverter *Inverter, address byte) error {
 
 // UnregisterInverter resets the inverter address (becomes 0x00)
 func (c *Client) UnregisterInverter(inverter *Inverter) error {
+	return c.UnregisterInverterContext(context.Background(), inverter)
+}
+
+func (c *Client) UnregisterInverterContext(ctx context.Context, inverter *Inverter) error {
 	if inverter == nil {
 		return fmt.Errorf("Inverter must not be nil")
 	}
 
-	err := c.Conn.Flush()
+	err := c.do(ctx, UnregisterInverterRequest(inverter.Serial, inverter.Address), ParseUnregisterInverterResponse)
 	if err != nil {
 		return err
 	}
 
-	// Send the request
-	req, err := UnregisterInverterRequest(inverter.Serial, inverter.Address).Bytes()
-	if err != nil {
-		return err
-	}
-	err = c.Send(req)
-	if err != nil {
-		return err
+	inverter.Address = 0x00
+	return nil
+}
+
+// ReconnectRemovedInverter asks a removed inverter to rejoin the bus at inverter.Address
+func (c *Client) ReconnectRemovedInverter(inverter *Inverter) error {
+	return c.ReconnectRemovedInverterContext(context.Background(), inverter)
+}
+
+func (c *Client) ReconnectRemovedInverterContext(ctx context.Context, inverter *Inverter) error {
+	if inverter == nil {
+		return fmt.Errorf("Inverter must not be nil")
 	}
 
-	// Get and handle the response
-	resp, err := c.Read()
-	if err != nil {
-		return err
+	return c.do(ctx, ReconnectRemovedInverterRequest(inverter.Serial, inverter.Address), ParseReconnectRemovedInverterResponse)
+}
+
+// ReregisterInverter registers an inverter that lost its registration (e.g. after a power cycle) at address
+func (c *Client) ReregisterInverter(inverter *Inverter, address byte) error {
+	return c.ReregisterInverterContext(context.Background(), inverter, address)
+}
+
+func (c *Client) ReregisterInverterContext(ctx context.Context, inverter *Inverter, address byte) error {
+	if inverter == nil {
+		return fmt.Errorf("Inverter must not be nil")
 	}
-	err = ParseUnregisterInverterResponse(resp)
+
+	err := c.do(ctx, ReregisterInverterRequest(inverter.Serial, address), ParseReregisterInverterResponse)
 	if err != nil {
 		return err
 	}
 
-	inverter.Address = 0x00
+	inverter.Address = address
 	return nil
 }
 
@@ -181,31 +234,69 @@ func (c *Client) UnregisterInverter(inverter *Inverter) error {
 */
 
 func (c *Client) GetInfo(inverter *Inverter) (*NormalInfoResponse, error) {
+	return c.GetInfoContext(context.Background(), inverter)
+}
+
+func (c *Client) GetInfoContext(ctx context.Context, inverter *Inverter) (*NormalInfoResponse, error) {
 	if inverter == nil {
 		return nil, fmt.Errorf("Inverter must not be nil")
 	}
 
-	err := c.Conn.Flush()
+	result, _, err := c.getInfo(ctx, inverter.Address)
 	if err != nil {
 		return nil, err
 	}
 
-	// Send the request
-	req, err := NormalInfoRequest(inverter.Address).Bytes()
-	if err != nil {
-		return nil, err
+	return &result, nil
+}
+
+// getInfo queries the info of the inverter at address and returns it together with the raw response
+func (c *Client) getInfo(ctx context.Context, address byte) (NormalInfoResponse, []byte, error) {
+	var result NormalInfoResponse
+	var raw []byte
+	err := c.do(ctx, NormalInfoRequest(address), func(resp []byte) (err error) {
+		result, err = ParseNormalInfoResponse(resp)
+		raw = resp
+		return err
+	})
+	return result, raw, err
+}
+
+func (c *Client) GetInverterInfo(inverter *Inverter) (*InverterInfoResponse, error) {
+	return c.GetInverterInfoContext(context.Background(), inverter)
+}
+
+func (c *Client) GetInverterInfoContext(ctx context.Context, inverter *Inverter) (*InverterInfoResponse, error) {
+	if inverter == nil {
+		return nil, fmt.Errorf("Inverter must not be nil")
 	}
-	err = c.Send(req)
+
+	var result InverterInfoResponse
+	err := c.do(ctx, InverterInfoRequest(inverter.Address), func(resp []byte) (err error) {
+		result, err = ParseInverterInfoResponse(resp)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Get and handle the response
-	resp, err := c.Read()
-	if err != nil {
-		return nil, err
+	return &result, nil
+}
+
+func (c *Client) GetConfig(inverter *Inverter) (*ConfigResponse, error) {
+	return c.GetConfigContext(context.Background(), inverter)
+}
+
+func (c *Client) GetConfigContext(ctx context.Context, inverter *Inverter) (*ConfigResponse, error) {
+	if inverter == nil {
+		return nil, fmt.Errorf("Inverter must not be nil")
 	}
-	result, err := ParseNormalInfoResponse(resp)
+
+	var result ConfigResponse
+	err := c.do(ctx, ConfigRequest(inverter.Address), func(resp []byte) (err error) {
+		result, err = ParseConfigResponse(resp)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -213,45 +304,233 @@ func (c *Client) GetInfo(inverter *Inverter) (*NormalInfoResponse, error) {
 	return &result, nil
 }
 
-func (c *Client) GetInverterInfo(inverter *Inverter) (*InverterInfoResponse, error) {
+// ScanOptions configures ScanAddresses
+type ScanOptions struct {
+	Timeout  time.Duration                          // Time to wait for a response from each address, defaults to 200ms
+	Progress func(address byte, result *ScanResult) // Called after each address, result is nil when nobody answered
+}
+
+// ScanResult is an inverter that answered during ScanAddresses
+type ScanResult struct {
+	Address byte
+	Info    InverterInfoResponse
+	Garbled bool `json:",omitempty"` // The answer failed to parse (ErrInvalidBody), e.g. two inverters registered at the same address. Info is empty.
+}
+
+// ScanAddresses sends an InverterInfoRequest to every address from..to (inclusive) and returns the inverters that answered.
+// An address without an answer (ErrEmptyBody, or no complete answer within Timeout) is skipped, a garbled answer is
+// returned as a Garbled result. Other errors, e.g. a closed connection, stop the scan and are returned with the results so far.
+func (c *Client) ScanAddresses(ctx context.Context, from, to byte, opts ScanOptions) ([]ScanResult, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = 200 * time.Millisecond
+	}
+	results := []ScanResult{}
+	for a := int(from); a <= int(to); a++ {
+		address := byte(a)
+		actx, cancel := context.WithTimeout(ctx, opts.Timeout)
+		info, err := c.GetInverterInfoContext(actx, &Inverter{Address: address})
+		cancel()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return results, ctxErr
+		}
+
+		var result *ScanResult
+		switch {
+		case err == nil:
+			result = &ScanResult{Address: address, Info: *info}
+		case errors.Is(err, ErrInvalidBody):
+			result = &ScanResult{Address: address, Garbled: true}
+		case errors.Is(err, ErrEmptyBody) || errors.Is(err, context.DeadlineExceeded):
+			// nobody at this address
+		default:
+			return results, fmt.Errorf("scanning address %d: %w", address, err)
+		}
+		if result != nil {
+			results = append(results, *result)
+		}
+		if opts.Progress != nil {
+			opts.Progress(address, result)
+		}
+	}
+	return results, nil
+}
+
+/*
+-------------------------------------------------------------------------------
+----- Calls related to inverter configuration
+-------------------------------------------------------------------------------
+*/
+
+// WriteConfig writes a single config parameter (value in raw units, see ConfigParameter.RawValue).
+// The config is read before and after the write, the returned changes show what actually changed on the inverter.
+func (c *Client) WriteConfig(inverter *Inverter, param ConfigParameter, value uint16) ([]ConfigChange, error) {
+	return c.WriteConfigContext(context.Background(), inverter, param, value)
+}
+
+func (c *Client) WriteConfigContext(ctx context.Context, inverter *Inverter, param ConfigParameter, value uint16) ([]ConfigChange, error) {
 	if inverter == nil {
 		return nil, fmt.Errorf("Inverter must not be nil")
 	}
 
-	err := c.Conn.Flush()
+	before, err := c.GetConfigContext(ctx, inverter)
+	if err != nil {
+		return nil, fmt.Errorf("reading config before write: %w", err)
+	}
+
+	err = c.do(ctx, WriteConfigRequest(inverter.Address, param, value), func(resp []byte) error {
+		return ParseWriteConfigResponse(resp, param)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Send the request
-	req, err := InverterInfoRequest(inverter.Address).Bytes()
+	after, err := c.GetConfigContext(ctx, inverter)
 	if err != nil {
+		return nil, fmt.Errorf("reading config after write: %w", err)
+	}
+
+	return DiffConfig(NormalizeConfigResponse(*before), NormalizeConfigResponse(*after)), nil
+}
+
+/*
+-------------------------------------------------------------------------------
+----- Calls related to executing commands
+-------------------------------------------------------------------------------
+*/
+
+// Execute asks the inverter to execute an action and waits for the ACK
+func (c *Client) Execute(inverter *Inverter, action ExecuteAction) error {
+	return c.ExecuteContext(context.Background(), inverter, action)
+}
+
+func (c *Client) ExecuteContext(ctx context.Context, inverter *Inverter, action ExecuteAction) error {
+	if inverter == nil {
+		return fmt.Errorf("Inverter must not be nil")
+	}
+
+	return c.do(ctx, ExecuteRequest(inverter.Address, action), func(resp []byte) error {
+		return ParseExecuteResponse(resp, action)
+	})
+}
+
+// PowerOn resumes exporting power to the grid
+func (c *Client) PowerOn(inverter *Inverter) error {
+	return c.Execute(inverter, ExecutePowerOn)
+}
+
+// PowerOff stops exporting power to the grid
+func (c *Client) PowerOff(inverter *Inverter) error {
+	return c.Execute(inverter, ExecutePowerOff)
+}
+
+// Restart restarts the inverter
+func (c *Client) Restart(inverter *Inverter) error {
+	return c.Execute(inverter, ExecuteRestart)
+}
+
+// StartSelftest starts the inverter self-test. GetInfo reports Mode 6 (Selftest) while it runs.
+func (c *Client) StartSelftest(inverter *Inverter) error {
+	return c.Execute(inverter, ExecuteSelftest)
+}
+
+/*
+-------------------------------------------------------------------------------
+----- COMMUNICATION
+-------------------------------------------------------------------------------
+*/
+
+// do runs the transaction for req and hands the response to handle, retrying according to the RetryPolicy
+func (c *Client) do(ctx context.Context, req *Packet, handle func(resp []byte) error) error {
+	atomic.AddUint64(&c.stats.Requests, 1)
+	attempts := c.RetryPolicy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	retryable := c.RetryPolicy.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	if !isQuery(req) {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var resp []byte
+		resp, err = c.request(ctx, req)
+		if err == nil {
+			err = handle(resp)
+		}
+		if err == nil || attempt >= attempts || !retryable(err) {
+			break
+		}
+
+		atomic.AddUint64(&c.stats.Retries, 1)
+		select {
+		case <-ctx.Done():
+			atomic.AddUint64(&c.stats.Failures, 1)
+			return ctx.Err()
+		case <-time.After(c.RetryPolicy.backoff(attempt)):
+		}
+	}
+	if err != nil {
+		atomic.AddUint64(&c.stats.Failures, 1)
+	}
+	return err
+}
+
+// isQuery reports whether req only reads from the inverters, so it is safe to send again
+func isQuery(req *Packet) bool {
+	return req.ControlCode == ControlCodeRead || (req.ControlCode == ControlCodeRegister && req.FunctionCode == 0x00)
+}
+
+// Request sends req and returns the raw response of this transaction (see ParsePacket and the Parse*Response functions).
+// The bus is held for the whole Flush/Send/Read cycle, which is bounded by both WaitTime and the deadline of ctx.
+// ctx.Err() is returned on cancellation. Request does not retry, the RetryPolicy only applies to the typed calls.
+func (c *Client) Request(ctx context.Context, req *Packet) ([]byte, error) {
+	return c.request(ctx, req)
+}
+
+func (c *Client) request(ctx context.Context, req *Packet) ([]byte, error) {
+	c.bus.Lock()
+	defer c.bus.Unlock()
+
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	err = c.Send(req)
+	err := c.Flush()
 	if err != nil {
 		return nil, err
 	}
 
-	// Get and handle the response
-	resp, err := c.Read()
+	// Send the request
+	body, err := req.Bytes()
 	if err != nil {
 		return nil, err
 	}
-	result, err := ParseInverterInfoResponse(resp)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	err = c.Send(body)
 	if err != nil {
 		return nil, err
 	}
 
-	return &result, nil
+	// Get the response
+	return c.ReadContext(ctx)
 }
 
-/*
--------------------------------------------------------------------------------
------ COMMUNICATION
--------------------------------------------------------------------------------
-*/
+// LastResponse returns a copy of the last raw response read by the client.
+// With concurrent use this may belong to another goroutine, use Request to get the response of a single call.
+func (c *Client) LastResponse() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.lastResponse...)
+}
 
+// Send writes req to the connection. Send, Flush and Read do not hold the bus, use Request to
+// run a full transaction when the Client is shared between goroutines.
 func (c *Client) Send(req []byte) error {
 	n, err := c.Conn.Write(req)
 	if err != nil {
@@ -263,9 +542,135 @@ func (c *Client) Send(req []byte) error {
 	return nil
 }
 
+// Flush discards all pending bytes on the connection
+func (c *Client) Flush() error {
+	c.frameReader().Reset()
+	return c.Conn.Flush()
+}
+
+// Read returns the next packet received within WaitTime, see FrameReader.ReadFrame
 func (c *Client) Read() ([]byte, error) {
-	time.Sleep(c.WaitTime)
-	response, err := io.ReadAll(c.Conn)
-	c.LastResponse = response
+	return c.ReadContext(context.Background())
+}
+
+// ReadContext is like Read, but returns early with ctx.Err() when ctx is done.
+func (c *Client) ReadContext(ctx context.Context) ([]byte, error) {
+	deadline := time.Now().Add(c.WaitTime)
+	frames := c.frameReader()
+	response, err := frames.ReadFrameContext(ctx, deadline)
+	// an invalid frame may start at a false header in line noise, the response can be in the bytes after it
+	for errors.Is(err, ErrInvalidBody) && frames.buffered() {
+		next, nextErr := frames.ReadFrameContext(ctx, deadline)
+		if nextErr != nil && !errors.Is(nextErr, ErrInvalidBody) {
+			break
+		}
+		if _, parseErr := ParsePacket(next); parseErr == nil {
+			response, err = next, nil
+		}
+	}
+	c.mu.Lock()
+	c.lastResponse = response
+	c.mu.Unlock()
 	return response, err
 }
+
+func (c *Client) frameReader() *FrameReader {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frames == nil || c.frames.r != c.Conn {
+		c.frames = NewFrameReader(c.Conn)
+	}
+	return c.frames
+}
+
+/*
+-------------------------------------------------------------------------------
+----- Commissioning
+-------------------------------------------------------------------------------
+*/
+
+// DiscoverOptions configures DiscoverAndRegisterAll
+type DiscoverOptions struct {
+	FirstAddress  byte   // Lowest address to assign, defaults to 1
+	Used          []byte // Addresses already in use on the bus, these are skipped
+	MaxCollisions int    // Garbled answers in a row (e.g. two inverters answering at once) before giving up, defaults to 10
+}
+
+// maxCollisionWait is the upper limit of the random wait before the query is repeated after a collision
+const maxCollisionWait = 200 * time.Millisecond
+
+// Registration is an inverter registered by DiscoverAndRegisterAll
+type Registration struct {
+	Inverter Inverter
+	Info     InverterInfoResponse
+}
+
+// DiscoverAndRegisterAll registers every unregistered inverter on the bus. It repeatedly queries address 0x00,
+// registers the responder at the next free address and verifies the registration with GetInverterInfo.
+// All unregistered inverters answer the same query, when their answers overlap they fail to parse (ErrInvalidBody).
+// The query is then repeated after a random wait: inverters that answer at slightly different times get through one
+// by one, and every registration takes an inverter off the query. Inverters that still collide after MaxCollisions
+// garbled answers in a row have to be powered on one at a time. The inverters registered so far are returned on error.
+func (c *Client) DiscoverAndRegisterAll(ctx context.Context, opts DiscoverOptions) ([]Registration, error) {
+	if opts.FirstAddress == 0 {
+		opts.FirstAddress = 1
+	}
+	if opts.MaxCollisions == 0 {
+		opts.MaxCollisions = 10
+	}
+	used := map[byte]bool{}
+	for _, a := range opts.Used {
+		used[a] = true
+	}
+	seen := map[string]bool{}
+
+	registered := []Registration{}
+	collisions := 0
+	next := int(opts.FirstAddress)
+	for {
+		inv, err := c.FindUnregisteredInverterContext(ctx)
+		if errors.Is(err, ErrNoInverter) {
+			return registered, nil
+		}
+		// registrations are verified, an inverter that answers again sent a late answer to an earlier query
+		late := err == nil && seen[string(inv.Serial)]
+		if errors.Is(err, ErrInvalidBody) || late {
+			collisions++
+			if collisions >= opts.MaxCollisions && late {
+				return registered, fmt.Errorf("inverter %X still unregistered after registration", inv.Serial)
+			}
+			if collisions >= opts.MaxCollisions {
+				return registered, fmt.Errorf("%d garbled answers in a row, several unregistered inverters keep answering at once (power them on one at a time): %w", collisions, err)
+			}
+			select {
+			case <-ctx.Done():
+				return registered, ctx.Err()
+			case <-time.After(time.Duration(rand.Int63n(int64(maxCollisionWait)))):
+			}
+			continue
+		}
+		if err != nil {
+			return registered, err
+		}
+		collisions = 0
+		seen[string(inv.Serial)] = true
+
+		for next <= 255 && used[byte(next)] {
+			next++
+		}
+		if next > 255 {
+			return registered, ErrNoFreeAddress
+		}
+		address := byte(next)
+
+		if err := c.RegisterInverterContext(ctx, inv, address); err != nil {
+			return registered, fmt.Errorf("registering %X at address %d: %w", inv.Serial, address, err)
+		}
+		used[address] = true
+		info, err := c.GetInverterInfoContext(ctx, inv)
+		if err != nil {
+			return registered, fmt.Errorf("verifying %X at address %d: %w", inv.Serial, address, err)
+		}
+		registered = append(registered, Registration{Inverter: *inv, Info: *info})
+	}
+}
@@ -1,40 +1,380 @@
 package solaxx1rs485
 
 import (
-	"log"
+	"context"
+	"io"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
-func TestConnection(t *testing.T) {
+// mockConnection answers every write with the next scripted response
+type mockConnection struct {
+	responses [][]byte
+	written   [][]byte
+	pending   []byte
+}
+
+func (m *mockConnection) Write(p []byte) (int, error) {
+	m.written = append(m.written, append([]byte{}, p...))
+	if len(m.responses) > 0 {
+		m.pending = append(m.pending, m.responses[0]...)
+		m.responses = m.responses[1:]
+	}
+	return len(p), nil
+}
+
+func (m *mockConnection) Read(p []byte) (int, error) {
+	if len(m.pending) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, m.pending)
+	m.pending = m.pending[n:]
+	return n, nil
+}
+
+func (m *mockConnection) Flush() error {
+	m.pending = nil
+	return nil
+}
+
+func (m *mockConnection) Close() error { return nil }
+
+func TestGetInfoContext(t *testing.T) {
+	p := DefaultPacket()
+	p.ControlCode = ControlCodeRead
+	p.FunctionCode = 0x82
+	p.Data = make([]byte, 50)
+	p.Data[19] = 0xFA // Power 250W
+	resp, err := p.Bytes()
+	require.NoError(t, err)
+
+	t.Run("Response", func(t *testing.T) {
+		conn := &mockConnection{responses: [][]byte{resp}}
+		c, _ := NewClientWithConnection(conn)
+		info, err := c.GetInfoContext(context.Background(), &Inverter{Address: 0x0A})
+		require.NoError(t, err)
+		require.Equal(t, uint16(250), info.Power)
+		require.Len(t, conn.written, 1)
+		require.Equal(t, byte(0x0A), conn.written[0][5])
+	})
+
+	t.Run("Response after line noise", func(t *testing.T) {
+		noise := []byte{0xAA, 0x55, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x08, 0x07}
+		conn := &mockConnection{responses: [][]byte{append(noise, resp...)}}
+		c, _ := NewClientWithConnection(conn)
+		info, err := c.GetInfoContext(context.Background(), &Inverter{Address: 0x0A})
+		require.NoError(t, err)
+		require.Equal(t, uint16(250), info.Power)
+	})
+
+	t.Run("Cancelled before sending", func(t *testing.T) {
+		conn := &mockConnection{responses: [][]byte{resp}}
+		c, _ := NewClientWithConnection(conn)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := c.GetInfoContext(ctx, &Inverter{Address: 0x0A})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Empty(t, conn.written)
+	})
 
-	log.Println("Starting Open")
-	c, _ := NewClient("/dev/tty.usbserial-A10KNFUE")
-	i, err := c.FindUnregisteredInverter()
+	t.Run("Deadline bounds the read", func(t *testing.T) {
+		conn := &mockConnection{}
+		c, _ := NewClientWithConnection(conn)
+		c.WaitTime = 10 * time.Second
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := c.GetInfoContext(ctx, &Inverter{Address: 0x0A})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("No response without context deadline", func(t *testing.T) {
+		conn := &mockConnection{}
+		c, _ := NewClientWithConnection(conn)
+		c.WaitTime = 10 * time.Millisecond
+		_, err := c.FindUnregisteredInverter()
+		require.ErrorIs(t, err, ErrNoInverter)
+	})
+}
+
+// busConnection answers NormalInfoRequests with a response that has Power set to the destination address
+type busConnection struct {
+	mu      sync.Mutex
+	pending []byte
+}
+
+func (b *busConnection) Write(p []byte) (int, error) {
+	req, err := ParsePacket(p)
 	if err != nil {
-		log.Fatal(err)
-	}
-	log.Fatalf("%+v", i)
-
-	// c := &serial.Config{Name: "/dev/tty.usbserial-A10KNFUE", Baud: 9600, ReadTimeout: 500 * time.Millisecond}
-	// s, err := serial.OpenPort(c)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-
-	// log.Println("Starting Write")
-	// n, err := s.Write([]byte("test"))
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	// log.Println("Completed Write")
-
-	// log.Println("Starting Read")
-	// buf := make([]byte, 128)
-	// n, err = s.Read(buf)
-	// if err != nil {
-	// 	log.Fatalf("got error while reading: %s", err)
-	// }
-	// log.Printf("%q", buf[:n])
-	// log.Println("Completed Read")
-	t.FailNow()
+		return 0, err
+	}
+	resp := DefaultPacket()
+	resp.Source = req.Destination
+	resp.ControlCode = ControlCodeRead
+	resp.FunctionCode = 0x82
+	resp.Data = make([]byte, 50)
+	resp.Data[19] = byte(req.Destination)
+	body, _ := resp.Bytes()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// respond in two parts, as a slow serial line would
+	b.pending = append(b.pending, body[:20]...)
+	go func() {
+		time.Sleep(time.Millisecond)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.pending = append(b.pending, body[20:]...)
+	}()
+	return len(p), nil
+}
+
+func (b *busConnection) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+func (b *busConnection) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = nil
+	return nil
+}
+
+func (b *busConnection) Close() error { return nil }
+
+func TestConcurrentRequests(t *testing.T) {
+	type result struct {
+		address byte
+		power   uint16
+		err     error
+	}
+	c, _ := NewClientWithConnection(&busConnection{})
+	results := make(chan result, 40)
+	var wg sync.WaitGroup
+	for address := 1; address <= 4; address++ {
+		wg.Add(1)
+		go func(address byte) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				r := result{address: address}
+				info, err := c.GetInfo(&Inverter{Address: address})
+				if r.err = err; err == nil {
+					r.power = info.Power
+				}
+				results <- r
+			}
+		}(byte(address))
+	}
+	wg.Wait()
+	close(results)
+
+	// require must not be called from the goroutines above, it stops the test with FailNow
+	for r := range results {
+		require.NoError(t, r.err)
+		require.Equal(t, uint16(r.address), r.power)
+	}
+	require.NotEmpty(t, c.LastResponse())
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := DefaultPacket()
+	p.ControlCode = ControlCodeRead
+	p.FunctionCode = 0x82
+	p.Data = make([]byte, 50)
+	good, err := p.Bytes()
+	require.NoError(t, err)
+	bad := append([]byte{}, good...)
+	bad[len(bad)-1]++ // checksum mismatch
+
+	t.Run("Retries checksum errors", func(t *testing.T) {
+		conn := &mockConnection{responses: [][]byte{bad, bad, good}}
+		c, _ := NewClientWithConnection(conn)
+		c.RetryPolicy = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+		_, err := c.GetInfo(&Inverter{Address: 0x01})
+		require.NoError(t, err)
+		require.Len(t, conn.written, 3)
+		require.Equal(t, Stats{Requests: 1, Retries: 2}, c.Stats())
+	})
+
+	t.Run("Gives up after MaxAttempts", func(t *testing.T) {
+		conn := &mockConnection{responses: [][]byte{bad, bad, good}}
+		c, _ := NewClientWithConnection(conn)
+		c.RetryPolicy = RetryPolicy{MaxAttempts: 2}
+		_, err := c.GetInfo(&Inverter{Address: 0x01})
+		require.ErrorIs(t, err, ErrInvalidBody)
+		require.Equal(t, Stats{Requests: 1, Retries: 1, Failures: 1}, c.Stats())
+	})
+
+	t.Run("No retries by default", func(t *testing.T) {
+		conn := &mockConnection{responses: [][]byte{bad, good}}
+		c, _ := NewClientWithConnection(conn)
+		_, err := c.GetInfo(&Inverter{Address: 0x01})
+		require.ErrorIs(t, err, ErrInvalidBody)
+		require.Len(t, conn.written, 1)
+	})
+
+	t.Run("Errors that are not retryable", func(t *testing.T) {
+		nack := DefaultPacket()
+		nack.ControlCode = ControlCodeExecute
+		nack.FunctionCode = 0x83
+		nack.Data = []byte{StatusNOACK}
+		body, err := nack.Bytes()
+		require.NoError(t, err)
+		conn := &mockConnection{responses: [][]byte{body, body}}
+		c, _ := NewClientWithConnection(conn)
+		c.RetryPolicy = RetryPolicy{MaxAttempts: 3}
+		require.ErrorIs(t, c.Restart(&Inverter{Address: 0x01}), ErrNOACK)
+		require.Len(t, conn.written, 1)
+	})
+
+	t.Run("Commands are sent once", func(t *testing.T) {
+		ack := DefaultPacket()
+		ack.ControlCode = ControlCodeExecute
+		ack.FunctionCode = 0x83
+		ack.Data = []byte{StatusACK}
+		body, err := ack.Bytes()
+		require.NoError(t, err)
+		body[len(body)-1]++ // the restart is applied, but its ACK is corrupted
+		conn := &mockConnection{responses: [][]byte{body, body}}
+		c, _ := NewClientWithConnection(conn)
+		c.RetryPolicy = RetryPolicy{MaxAttempts: 3}
+		require.ErrorIs(t, c.Execute(&Inverter{Address: 0x01}, ExecuteRestart), ErrInvalidBody)
+		require.Len(t, conn.written, 1)
+		require.Equal(t, Stats{Requests: 1, Failures: 1}, c.Stats())
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		r := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+		require.Equal(t, 100*time.Millisecond, r.backoff(1))
+		require.Equal(t, 200*time.Millisecond, r.backoff(2))
+		require.Equal(t, 300*time.Millisecond, r.backoff(3))
+		require.Equal(t, 300*time.Millisecond, r.backoff(10))
+	})
+}
+
+func TestDiscoverAndRegisterAll(t *testing.T) {
+	serialA, serialB := "XB3002IAAAAAAA", "XB3002IBBBBBBB"
+	ack := testResponse(t, ControlCodeRegister, 0x81, []byte{StatusACK})
+	conn := &mockConnection{responses: [][]byte{
+		testResponse(t, ControlCodeRegister, 0x80, []byte(serialA)), ack, testInverterInfo(t, serialA),
+		testResponse(t, ControlCodeRegister, 0x80, []byte(serialB)), ack, testInverterInfo(t, serialB),
+	}}
+	c, _ := NewClientWithConnection(conn)
+	c.WaitTime = 10 * time.Millisecond
+
+	registered, err := c.DiscoverAndRegisterAll(context.Background(), DiscoverOptions{Used: []byte{1}})
+	require.NoError(t, err)
+	require.Len(t, registered, 2)
+	require.Equal(t, Inverter{Serial: []byte(serialA), Address: 2}, registered[0].Inverter)
+	require.Equal(t, Inverter{Serial: []byte(serialB), Address: 3}, registered[1].Inverter)
+	require.Equal(t, serialB, registered[1].Info.SerialNumber)
+	require.Len(t, conn.written, 7) // 2x (find, register, verify), final find
+
+	collision := testResponse(t, ControlCodeRegister, 0x80, []byte(serialA))
+	collision[len(collision)-1] ^= 0xFF
+
+	t.Run("Colliding responses are queried again", func(t *testing.T) {
+		conn := &mockConnection{responses: [][]byte{
+			collision, collision, testResponse(t, ControlCodeRegister, 0x80, []byte(serialA)), ack, testInverterInfo(t, serialA),
+		}}
+		c, _ := NewClientWithConnection(conn)
+		c.WaitTime = 10 * time.Millisecond
+		registered, err := c.DiscoverAndRegisterAll(context.Background(), DiscoverOptions{})
+		require.NoError(t, err)
+		require.Len(t, registered, 1)
+		require.Len(t, conn.written, 6) // 3x find, register, verify, final find
+	})
+
+	t.Run("Inverters that keep colliding", func(t *testing.T) {
+		conn := &mockConnection{responses: [][]byte{collision, collision, collision}}
+		c, _ := NewClientWithConnection(conn)
+		c.WaitTime = 10 * time.Millisecond
+		registered, err := c.DiscoverAndRegisterAll(context.Background(), DiscoverOptions{MaxCollisions: 3})
+		require.ErrorIs(t, err, ErrInvalidBody)
+		require.Contains(t, err.Error(), "one at a time")
+		require.Empty(t, registered)
+		require.Len(t, conn.written, 3)
+	})
+
+	t.Run("Inverter that does not register", func(t *testing.T) {
+		nack := testResponse(t, ControlCodeRegister, 0x81, []byte{StatusNOACK})
+		conn := &mockConnection{responses: [][]byte{testResponse(t, ControlCodeRegister, 0x80, []byte(serialA)), nack}}
+		c, _ := NewClientWithConnection(conn)
+		registered, err := c.DiscoverAndRegisterAll(context.Background(), DiscoverOptions{})
+		require.Error(t, err)
+		require.Empty(t, registered)
+	})
+}
+
+// addressConnection answers InverterInfoRequests only for the given addresses, with a corrupt checksum
+// for the garbled addresses. Writes fail with io.ErrClosedPipe once closed.
+type addressConnection struct {
+	mockConnection
+	t         *testing.T
+	addresses map[byte]bool
+	garbled   map[byte]bool
+	closed    bool
+}
+
+func (a *addressConnection) Write(p []byte) (int, error) {
+	if a.closed {
+		return 0, io.ErrClosedPipe
+	}
+	req, err := ParsePacket(p)
+	require.NoError(a.t, err)
+	if a.addresses[byte(req.Destination)] {
+		resp := testInverterInfo(a.t, "XB3002I000000"+string(rune('0'+req.Destination)))
+		if a.garbled[byte(req.Destination)] {
+			resp[len(resp)-1] ^= 0xFF
+		}
+		a.pending = append(a.pending, resp...)
+	}
+	return len(p), nil
+}
+
+func TestScanAddresses(t *testing.T) {
+	conn := &addressConnection{t: t, addresses: map[byte]bool{2: true, 5: true, 6: true}, garbled: map[byte]bool{6: true}}
+	c, _ := NewClientWithConnection(conn)
+
+	scanned := []byte{}
+	results, err := c.ScanAddresses(context.Background(), 1, 6, ScanOptions{
+		Timeout: 10 * time.Millisecond,
+		Progress: func(address byte, result *ScanResult) {
+			scanned = append(scanned, address)
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3, 4, 5, 6}, scanned)
+	require.Len(t, results, 3)
+	require.Equal(t, byte(2), results[0].Address)
+	require.Equal(t, "XB3002I0000002", results[0].Info.SerialNumber)
+	require.Equal(t, byte(5), results[1].Address)
+	require.False(t, results[1].Garbled)
+	require.Equal(t, ScanResult{Address: 6, Garbled: true}, results[2])
+
+	t.Run("Connection error", func(t *testing.T) {
+		conn.closed = true
+		defer func() { conn.closed = false }()
+		results, err := c.ScanAddresses(context.Background(), 1, 255, ScanOptions{Timeout: 10 * time.Millisecond})
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+		require.Empty(t, results)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := c.ScanAddresses(ctx, 1, 255, ScanOptions{})
+		require.ErrorIs(t, err, context.Canceled)
+	})
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+)
+
+var alertConfig string
+
+// newAlertQueue returns a queue for the engine configured in --alerts, nil when not set
+func newAlertQueue() *solax.AlertQueue {
+	if alertConfig == "" {
+		return nil
+	}
+	config, err := solax.LoadAlertConfig(alertConfig)
+	fatalIfError(err)
+	engine, err := solax.NewAlertEngine(config)
+	fatalIfError(err)
+	return solax.NewAlertQueue(engine, 100, func(a solax.Alert, err error) {
+		log.Printf("Sending alert %q: %s", a, err)
+	})
+}
+
+// closeAlertQueue sends the queued alerts, giving up after 30 seconds
+func closeAlertQueue(queue *solax.AlertQueue) {
+	if queue == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := queue.Close(ctx); err != nil {
+		log.Printf("Sending alerts: %s", err)
+	}
+}
+
+// evaluateAlerts queues the alerts for s, the alerts are logged when logAlerts is set
+func evaluateAlerts(queue *solax.AlertQueue, s solax.Sample, logAlerts bool) {
+	if queue == nil {
+		return
+	}
+	alerts := queue.Handle(s)
+	if logAlerts {
+		for _, a := range alerts {
+			log.Printf("Alert %s", a)
+		}
+	}
+}
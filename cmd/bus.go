@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var busCmd = &cobra.Command{
+	Use:   "bus",
+	Short: "Manage the registry of inverters on the bus",
+}
+var busListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the known inverters",
+	Run:   BusList,
+}
+var busAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Register the next unregistered inverter at a free address and add it to the registry",
+	Run:   BusAdd,
+}
+var busRefreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "Query all known inverters and update their details in the registry",
+	Run:   BusRefresh,
+}
+var busRemoveCmd = &cobra.Command{
+	Use:   "remove <address>",
+	Short: "Remove an inverter from the registry (the inverter itself is not unregistered)",
+	Args:  cobra.ExactArgs(1),
+	Run:   BusRemove,
+}
+
+var commissionCmd = &cobra.Command{
+	Use:   "commission",
+	Short: "Register all unregistered inverters on the bus at free addresses",
+	Run:   Commission,
+}
+
+func Commission(cmd *cobra.Command, args []string) {
+	client, err := newClient()
+	fatalIfError(err)
+	bus, err := solax.NewBus(client, registry)
+	fatalIfError(err)
+
+	registered, err := bus.Commission(context.Background())
+	if len(registered) == 0 && err == nil {
+		log.Print("No unregistered inverters found")
+		os.Exit(0)
+	}
+	printKnownInverters(registered)
+	fatalIfError(err)
+}
+
+func BusList(cmd *cobra.Command, args []string) {
+	bus, err := solax.NewBus(nil, registry)
+	fatalIfError(err)
+	printKnownInverters(bus.Inverters())
+}
+
+func BusAdd(cmd *cobra.Command, args []string) {
+	client, err := newClient()
+	fatalIfError(err)
+	bus, err := solax.NewBus(client, registry)
+	fatalIfError(err)
+
+	inv, err := bus.RegisterNew(context.Background())
+	if errors.Is(err, solax.ErrNoInverter) {
+		log.Print("No unregistered inverters found")
+		os.Exit(0)
+	}
+	fatalIfError(err)
+	log.Printf("Inverter %s registered with address %d", inv.SerialNumber, inv.Address)
+}
+
+func BusRefresh(cmd *cobra.Command, args []string) {
+	client, err := newClient()
+	fatalIfError(err)
+	bus, err := solax.NewBus(client, registry)
+	fatalIfError(err)
+
+	err = bus.Refresh(context.Background())
+	printKnownInverters(bus.Inverters())
+	fatalIfError(err)
+}
+
+func BusRemove(cmd *cobra.Command, args []string) {
+	a, err := strconv.ParseUint(args[0], 10, 8)
+	fatalIfError(err)
+	bus, err := solax.NewBus(nil, registry)
+	fatalIfError(err)
+	fatalIfError(bus.Remove(byte(a)))
+}
+
+func printKnownInverters(inverters []solax.KnownInverter) {
+	if outputJson {
+		out, err := json.Marshal(inverters)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	data := pterm.TableData{{"Address", "SerialNumber", "Model", "FirmwareVersion", "Serial"}}
+	for _, inv := range inverters {
+		data = append(data, []string{fmt.Sprintf("%d", inv.Address), inv.SerialNumber, inv.Model, inv.FirmwareVersion, fmt.Sprintf("%X", inv.Serial)})
+	}
+	pterm.DefaultSection.Println("Known inverters:")
+	pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
+
+// targetInverter returns the inverter selected with --serial (looked up in the registry) or --address
+func targetInverter() *solax.Inverter {
+	if invSerial != "" {
+		bus, err := solax.NewBus(nil, registry)
+		fatalIfError(err)
+		known, err := bus.Lookup(invSerial)
+		fatalIfError(err)
+		return known.Inverter()
+	}
+	if address < 0 || address > 255 {
+		log.Fatal("Address must be between 1-255")
+	}
+	return &solax.Inverter{Address: byte(address)}
+}
+
+func defaultRegistryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "solax-registry.json"
+	}
+	return filepath.Join(dir, "solax", "registry.json")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exporterListen  string
+	exporterTimeout time.Duration
+)
+
+func init() {
+	exporterCmd.Flags().StringVar(&exporterListen, "listen", ":9100", "Address to serve /metrics on")
+	exporterCmd.Flags().DurationVar(&exporterTimeout, "timeout", 5*time.Second, "Maximum time to query a single inverter during a scrape")
+	exporterCmd.Flags().BoolVar(&monitorAll, "all", false, "Export all inverters in the registry")
+	exporterCmd.Flags().StringVar(&invSerial, "serial", "", "Serial of the inverter to export, instead of --address (see 'bus list')")
+	rootCmd.AddCommand(exporterCmd)
+}
+
+var exporterCmd = &cobra.Command{
+	Use:   "exporter",
+	Short: "Serve real-time inverter information as Prometheus metrics",
+	Run:   Exporter,
+}
+
+func Exporter(cmd *cobra.Command, args []string) {
+	inverters := monitoredInverters()
+	client, err := newClient()
+	fatalIfError(err)
+
+	e := &exporter{client: client, inverters: inverters, devices: map[byte]solax.InverterInfoResponse{}, errors: map[byte]uint64{}}
+	http.Handle("/metrics", e)
+	http.Handle("/", http.RedirectHandler("/metrics", http.StatusFound))
+	log.Printf("Serving metrics on %s/metrics", exporterListen)
+	log.Fatal(http.ListenAndServe(exporterListen, nil))
+}
+
+// exporter queries all inverters on every scrape
+type exporter struct {
+	client    *solax.Client
+	inverters []solax.Inverter
+
+	mu      sync.Mutex                          // serializes scrapes
+	devices map[byte]solax.InverterInfoResponse // device details per address, from GetInverterInfo
+	errors  map[byte]uint64                     // failed scrapes per address
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	m := &solax.PrometheusMetrics{}
+	for _, inv := range e.inverters {
+		e.collect(r.Context(), m, inv)
+	}
+	stats := e.client.Stats()
+	m.Add("solax_requests_total", "counter", "Requests sent to the inverters, excluding retries", "", float64(stats.Requests))
+	m.Add("solax_retries_total", "counter", "Requests that were retried after a bad or missing response", "", float64(stats.Retries))
+	m.Add("solax_request_failures_total", "counter", "Requests that failed after all retries", "", float64(stats.Failures))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+func (e *exporter) collect(ctx context.Context, m *solax.PrometheusMetrics, inv solax.Inverter) {
+	ctx, cancel := context.WithTimeout(ctx, exporterTimeout)
+	defer cancel()
+	start := time.Now()
+
+	// the device details are only queried once, they are not part of the labels of the other metrics
+	var err error
+	device, ok := e.devices[inv.Address]
+	if !ok {
+		var res *solax.InverterInfoResponse
+		if res, err = e.client.GetInverterInfoContext(ctx, &inv); err == nil {
+			device, ok = *res, true
+			e.devices[inv.Address] = device
+		}
+	}
+	var info *solax.NormalInfoResponse
+	if err == nil {
+		info, err = e.client.GetInfoContext(ctx, &inv)
+	}
+	duration := time.Since(start)
+	if err != nil {
+		e.errors[inv.Address]++
+		log.Printf("Scraping inverter %d: %s", inv.Address, err)
+	}
+
+	labels := solax.PrometheusLabels("address", strconv.Itoa(int(inv.Address)))
+	if ok {
+		m.AddDevice(labels, device)
+	}
+	up := 0.0
+	if err == nil {
+		up = 1
+	}
+	m.Add("solax_up", "gauge", "Whether the last scrape of the inverter succeeded", labels, up)
+	m.Add("solax_scrape_duration_seconds", "gauge", "Time it took to query the inverter", labels, duration.Seconds())
+	m.Add("solax_scrape_errors_total", "counter", "Failed scrapes of the inverter", labels, float64(e.errors[inv.Address]))
+	if err == nil {
+		m.AddInfo(labels, *info)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	faultHistory string
+	faultsSince  string
+)
+
+func init() {
+	faultsCmd.Flags().StringVar(&faultHistory, "history", defaultFaultHistoryPath(), "Location of the fault history written by 'monitor' and 'log'")
+	faultsCmd.Flags().StringVar(&faultsSince, "since", "168h", "Show episodes ongoing since (same formats as 'history --from')")
+	rootCmd.AddCommand(faultsCmd)
+}
+
+var faultsCmd = &cobra.Command{
+	Use:   "faults",
+	Short: "List recent fault episodes recorded by 'monitor' and 'log'",
+	Run:   Faults,
+}
+
+func Faults(cmd *cobra.Command, args []string) {
+	now := time.Now()
+	since, err := parseTime(faultsSince, now)
+	fatalIfError(err)
+	tracker, err := solax.NewFaultTracker(faultHistory)
+	fatalIfError(err)
+	episodes := tracker.Episodes(since)
+
+	if outputJson {
+		out, err := json.Marshal(episodes)
+		fatalIfError(err)
+		fmt.Println(string(out))
+		return
+	}
+	if len(episodes) == 0 {
+		log.Printf("No faults since %s", since.Format("2006-01-02 15:04"))
+		return
+	}
+
+	data := pterm.TableData{{"Address", "Fault", "Category", "Severity", "Start", "End", "Duration"}}
+	for _, e := range episodes {
+		category, severity := "-", "-"
+		if e.Mode == nil {
+			category, severity = e.Fault.Category().String(), e.Fault.Severity().String()
+		}
+		end := "ongoing"
+		if !e.Ongoing() {
+			end = e.End.Local().Format("2006-01-02 15:04:05")
+		}
+		data = append(data, []string{strconv.Itoa(int(e.Address)), e.Name(), category, severity, e.Start.Local().Format("2006-01-02 15:04:05"), end, e.Duration(now).Round(time.Second).String()})
+	}
+	pterm.DefaultSection.Println("Fault episodes:")
+	pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
+
+// newFaultTracker returns the tracker for the fault history at path, nil when disabled
+func newFaultTracker(path string) *solax.FaultTracker {
+	if path == "" {
+		return nil
+	}
+	tracker, err := solax.NewFaultTracker(path)
+	fatalIfError(err)
+	return tracker
+}
+
+// trackFaults records the fault events of s, the events are logged when logEvents is set
+func trackFaults(tracker *solax.FaultTracker, s solax.Sample, logEvents bool) {
+	if tracker == nil {
+		return
+	}
+	events, err := tracker.Track(s)
+	if err != nil {
+		log.Printf("Saving fault history: %s", err)
+	}
+	if logEvents {
+		for _, e := range events {
+			log.Print(e)
+		}
+	}
+}
+
+func defaultFaultHistoryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "solax-faults.json"
+	}
+	return filepath.Join(dir, "solax", "faults.json")
+}
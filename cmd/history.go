@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyFrom       string
+	historyTo         string
+	historyField      string
+	historyResolution time.Duration
+	historyCSV        bool
+	historyStore      string
+)
+
+func init() {
+	historyCmd.Flags().StringVar(&historyStore, "store", defaultStorePath(), "Location of the store written by 'log --store'")
+	historyCmd.Flags().StringVar(&historyFrom, "from", "24h", "Start of the period: a date (2006-01-02), local time (2006-01-02 15:04), RFC3339 or a duration ago (24h)")
+	historyCmd.Flags().StringVar(&historyTo, "to", "", "End of the period (same formats as --from), defaults to now")
+	historyCmd.Flags().StringVar(&historyField, "field", "Power", "Numeric field of the real-time information to aggregate")
+	historyCmd.Flags().DurationVar(&historyResolution, "resolution", 15*time.Minute, "Aggregation interval")
+	historyCmd.Flags().BoolVar(&historyCSV, "csv", false, "Export as CSV")
+	rootCmd.AddCommand(historyCmd)
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the min/avg/max of a field over time from the local store",
+	Run:   History,
+}
+
+func History(cmd *cobra.Command, args []string) {
+	now := time.Now()
+	from, err := parseTime(historyFrom, now)
+	fatalIfError(err)
+	to := now
+	if historyTo != "" {
+		to, err = parseTime(historyTo, now)
+		fatalIfError(err)
+	}
+
+	store, err := solax.OpenStoreReadOnly(historyStore)
+	fatalIfError(err)
+	defer store.Close()
+	records, err := store.Records(from, to)
+	fatalIfError(err)
+	aggregates, err := solax.AggregateRecords(records, historyField, historyResolution)
+	fatalIfError(err)
+
+	switch {
+	case outputJson:
+		out, err := json.Marshal(aggregates)
+		fatalIfError(err)
+		fmt.Println(string(out))
+	case historyCSV:
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"Start", "Address", "Count", "Min", "Avg", "Max"})
+		for _, a := range aggregates {
+			w.Write(aggregateRow(a, time.RFC3339))
+		}
+		w.Flush()
+		fatalIfError(w.Error())
+	default:
+		if len(aggregates) == 0 {
+			log.Printf("No samples between %s and %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+			return
+		}
+		data := pterm.TableData{{"Start", "Address", "Count", "Min", "Avg", "Max"}}
+		for _, a := range aggregates {
+			data = append(data, aggregateRow(a, "2006-01-02 15:04"))
+		}
+		pterm.DefaultSection.Printf("%s per %s:", historyField, historyResolution)
+		pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+	}
+}
+
+func aggregateRow(a solax.Aggregate, layout string) []string {
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []string{a.Start.Local().Format(layout), strconv.Itoa(int(a.Address)), strconv.Itoa(a.Count), format(a.Min), format(a.Avg), format(a.Max)}
+}
+
+// parseTime parses s as a date or time in local time, RFC3339, or a duration before now
+func parseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(strings.TrimPrefix(s, "-")); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use 2006-01-02, 2006-01-02 15:04, RFC3339 or a duration like 24h", s)
+}
+
+func defaultStorePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "solax-samples.db"
+	}
+	return filepath.Join(dir, "solax", "samples.db")
+}
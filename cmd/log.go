@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logInflux      string
+	logInfluxURL   string
+	logInfluxToken string
+	logCSVDir      string
+	logCSVPattern  string
+	logStore       string
+)
+
+func init() {
+	logCmd.Flags().StringVar(&logInflux, "influx", "", "Write InfluxDB line protocol to this file ('-' for stdout)")
+	logCmd.Flags().StringVar(&logInfluxURL, "influx-url", "", "Post InfluxDB line protocol to this write URL, e.g. http://localhost:8086/api/v2/write?org=home&bucket=solax")
+	logCmd.Flags().StringVar(&logInfluxToken, "influx-token", "", "InfluxDB API token for --influx-url")
+	logCmd.Flags().StringVar(&logCSVDir, "csv-dir", "", "Write CSV files to this directory")
+	logCmd.Flags().StringVar(&logCSVPattern, "csv-pattern", "solax-2006-01-02.csv", "CSV file name as a Go time layout, a new file is started when the name changes")
+	logCmd.Flags().StringVar(&logStore, "store", "", "Record samples in a local store for 'history' (--store without a value uses the default location)")
+	logCmd.Flags().Lookup("store").NoOptDefVal = defaultStorePath()
+	logCmd.Flags().StringVar(&faultHistory, "fault-history", defaultFaultHistoryPath(), "Record fault episodes for 'faults' here, empty to disable")
+	logCmd.Flags().StringVar(&alertConfig, "alerts", "", "Evaluate the alert rules and notifiers in this JSON file")
+	logCmd.Flags().DurationVar(&monitorInterval, "interval", 10*time.Second, "Time between polls")
+	logCmd.Flags().BoolVar(&monitorAll, "all", false, "Log all inverters in the registry")
+	logCmd.Flags().StringVar(&invSerial, "serial", "", "Serial of the inverter to log, instead of --address (see 'bus list')")
+	rootCmd.AddCommand(logCmd)
+}
+
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Continuously write real-time information to InfluxDB and/or CSV files",
+	Run:   Log,
+}
+
+func Log(cmd *cobra.Command, args []string) {
+	sinks := logSinks()
+	if len(sinks) == 0 {
+		log.Fatal("No output configured, use --influx, --influx-url, --csv-dir and/or --store")
+	}
+	defer func() {
+		for _, s := range sinks {
+			s.Close()
+		}
+	}()
+
+	inverters := monitoredInverters()
+	client, err := newClient()
+	fatalIfError(err)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	tracker := newFaultTracker(faultHistory)
+	alerts := newAlertQueue()
+	defer closeAlertQueue(alerts)
+	solax.NewPoller(client, monitorInterval, inverters...).Run(ctx, func(s solax.Sample) {
+		trackFaults(tracker, s, true)
+		evaluateAlerts(alerts, s, true)
+		if s.Err != nil {
+			log.Printf("Inverter %d: %s", s.Inverter.Address, s.Err)
+			return
+		}
+		for _, sink := range sinks {
+			if err := sink.Write(s); err != nil {
+				log.Printf("Writing sample: %s", err)
+			}
+		}
+	})
+}
+
+func logSinks() []solax.Sink {
+	sinks := []solax.Sink{}
+	switch logInflux {
+	case "":
+	case "-":
+		sinks = append(sinks, solax.NewInfluxSink(os.Stdout))
+	default:
+		s, err := solax.NewInfluxFileSink(logInflux)
+		fatalIfError(err)
+		sinks = append(sinks, s)
+	}
+	if logInfluxURL != "" {
+		sinks = append(sinks, solax.NewInfluxHTTPSink(logInfluxURL, logInfluxToken))
+	}
+	if logCSVDir != "" {
+		s := solax.NewCSVSink(logCSVDir)
+		s.Pattern = logCSVPattern
+		sinks = append(sinks, s)
+	}
+	if logStore != "" {
+		s, err := solax.OpenStore(logStore)
+		fatalIfError(err)
+		sinks = append(sinks, s)
+	}
+	return sinks
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,27 +17,56 @@ import (
 
 // used for flags
 var (
-	outputJson bool
-	verbose    bool
-	device     string
-	address    int
-	serial     []byte
+	outputJson  bool
+	verbose     bool
+	device      string
+	address     int
+	serial      []byte
+	dryRun      bool
+	confirmed   bool
+	retries     int
+	registry    string
+	invSerial   string
+	capture     string
+	captureFile *os.File
 )
 
 func init() {
-	rootCmd.PersistentFlags().StringVarP(&device, "device", "d", "", "Serial device for communication")
+	rootCmd.PersistentFlags().StringVarP(&device, "device", "d", "", "Serial device for communication, or tcp://host:port for an RS485-to-Ethernet gateway")
 	rootCmd.PersistentFlags().IntVarP(&address, "address", "a", 0x00, "Address on which to connect with Solax inverter (1..255)")
 	rootCmd.PersistentFlags().BoolVarP(&outputJson, "json", "j", false, "Output results as JSON")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "")
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", 0, "Number of times to retry a query after a bad or missing response")
+	rootCmd.PersistentFlags().StringVar(&registry, "registry", defaultRegistryPath(), "Registry file with the known inverters on the bus")
+	rootCmd.PersistentFlags().StringVar(&capture, "capture", "", "Record all bytes sent and received in this file, e.g. to attach to a bug report")
 	rootCmd.MarkFlagRequired("device")
+	for _, c := range []*cobra.Command{infoCmd, inverterInfoCmd, configCmd, execCmd} {
+		c.PersistentFlags().StringVar(&invSerial, "serial", "", "Serial of the inverter to connect with, instead of --address (see 'bus list')")
+	}
 	registerCmd.PersistentFlags().BytesHexVarP(&serial, "serial", "s", nil, "Inverter serial")
 	registerCmd.MarkFlagRequired("serial")
 	registerCmd.MarkFlagRequired("address")
 	rootCmd.AddCommand(findCmd)
 	rootCmd.AddCommand(registerCmd)
 	rootCmd.AddCommand(unregisterCmd)
+	for _, c := range []*cobra.Command{reconnectCmd, reregisterCmd} {
+		c.PersistentFlags().BytesHexVarP(&serial, "serial", "s", nil, "Inverter serial")
+		c.MarkPersistentFlagRequired("serial")
+		rootCmd.AddCommand(c)
+	}
 	rootCmd.AddCommand(infoCmd)
 	rootCmd.AddCommand(inverterInfoCmd)
+	rootCmd.AddCommand(configCmd)
+	configSetCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only print the packet that would be sent")
+	configCmd.AddCommand(configSetCmd)
+	execCmd.PersistentFlags().BoolVar(&confirmed, "yes", false, "Confirm that the command should be executed")
+	for _, action := range []solax.ExecuteAction{solax.ExecutePowerOn, solax.ExecutePowerOff, solax.ExecuteRestart, solax.ExecuteSelftest} {
+		execCmd.AddCommand(newExecActionCmd(action))
+	}
+	rootCmd.AddCommand(execCmd)
+	busCmd.AddCommand(busListCmd, busAddCmd, busRefreshCmd, busRemoveCmd)
+	rootCmd.AddCommand(busCmd)
+	rootCmd.AddCommand(commissionCmd)
 }
 
 func main() {
@@ -64,7 +94,9 @@ B. Get the information from your inverter:
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	closeCapture()
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
@@ -85,6 +117,16 @@ var unregisterCmd = &cobra.Command{
 	Short: "Remove the registration from an inverter",
 	Run:   Unregister,
 }
+var reconnectCmd = &cobra.Command{
+	Use:   "reconnect",
+	Short: "Reconnect a removed inverter at its previous address",
+	Run:   Reconnect,
+}
+var reregisterCmd = &cobra.Command{
+	Use:   "reregister",
+	Short: "Register an inverter again that lost its registration (e.g. after a power cycle)",
+	Run:   Reregister,
+}
 var infoCmd = &cobra.Command{
 	Use:   "info",
 	Short: "Get real-time inverter information",
@@ -95,13 +137,30 @@ var inverterInfoCmd = &cobra.Command{
 	Short: "Get inverter device details",
 	Run:   DeviceInfo,
 }
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Get inverter configuration (grid protection settings)",
+	Run:   Config,
+}
+var execCmd = &cobra.Command{
+	Use:   "exec",
+	Short: "Execute a command on the inverter (requires --yes)",
+}
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Write a single configuration value (e.g. 'config set PowerLimit 70')",
+	Long: `Write a single configuration value. Values are given in normalized units (V, Hz, A, s, %).
+Writable keys: ` + configKeys(),
+	Args: cobra.ExactArgs(2),
+	Run:  ConfigSet,
+}
 
 func Find(cmd *cobra.Command, args []string) {
-	client, err := solax.NewClient(device)
+	client, err := newClient()
 	fatalIfError(err)
 	inv, err := client.FindUnregisteredInverter()
 	if verbose {
-		log.Printf("Raw response: %X", client.LastResponse)
+		log.Printf("Raw response: %X", client.LastResponse())
 	}
 
 	if err != nil {
@@ -122,14 +181,14 @@ func Register(cmd *cobra.Command, args []string) {
 		log.Fatal("You need to provide a valid serial")
 	}
 
-	client, err := solax.NewClient(device)
+	client, err := newClient()
 	fatalIfError(err)
 	inv := &solax.Inverter{Serial: serial, Address: 0x00}
 
 	err = client.RegisterInverter(inv, byte(address))
 	fatalIfError(err)
 	if verbose {
-		log.Printf("Raw response: %X", client.LastResponse)
+		log.Printf("Raw response: %X", client.LastResponse())
 	}
 
 	log.Printf("Inverter registered with address %X", address)
@@ -139,31 +198,67 @@ func Unregister(cmd *cobra.Command, args []string) {
 		log.Fatal("Address must be between 1-255")
 	}
 
-	client, err := solax.NewClient(device)
+	client, err := newClient()
 	fatalIfError(err)
 	inv := &solax.Inverter{Serial: serial, Address: byte(address)}
 
 	err = client.UnregisterInverter(inv)
 	fatalIfError(err)
 	if verbose {
-		log.Printf("Raw response: %X", client.LastResponse)
+		log.Printf("Raw response: %X", client.LastResponse())
 	}
 
 	log.Printf("Inverter with address %X no longer registered", address)
 }
+func Reconnect(cmd *cobra.Command, args []string) {
+	if address < 1 || address > 255 {
+		log.Fatal("Address must be between 1-255")
+	}
+	if len(serial) < 10 {
+		log.Fatal("You need to provide a valid serial")
+	}
 
-func Info(cmd *cobra.Command, args []string) {
-	if address < 0 || address > 255 {
+	client, err := newClient()
+	fatalIfError(err)
+	inv := &solax.Inverter{Serial: serial, Address: byte(address)}
+
+	err = client.ReconnectRemovedInverter(inv)
+	if verbose {
+		log.Printf("Raw response: %X", client.LastResponse())
+	}
+	fatalIfError(err)
+
+	log.Printf("Inverter reconnected with address %X", address)
+}
+func Reregister(cmd *cobra.Command, args []string) {
+	if address < 1 || address > 255 {
 		log.Fatal("Address must be between 1-255")
 	}
+	if len(serial) < 10 {
+		log.Fatal("You need to provide a valid serial")
+	}
 
-	client, err := solax.NewClient(device)
+	client, err := newClient()
+	fatalIfError(err)
+	inv := &solax.Inverter{Serial: serial}
+
+	err = client.ReregisterInverter(inv, byte(address))
+	if verbose {
+		log.Printf("Raw response: %X", client.LastResponse())
+	}
+	fatalIfError(err)
+
+	log.Printf("Inverter registered again with address %X", address)
+}
+
+func Info(cmd *cobra.Command, args []string) {
+	inv := targetInverter()
+	client, err := newClient()
 	fatalIfError(err)
 
-	inv := &solax.Inverter{Address: byte(address)}
 	info, err := client.GetInfo(inv)
 	if verbose {
-		log.Printf("Raw response: %X", client.LastResponse)
+		log.Printf("Raw response: %X", client.LastResponse())
 	}
 	fatalIfError(err)
 
@@ -177,8 +272,17 @@ func Info(cmd *cobra.Command, args []string) {
 		os.Exit(0)
 	}
 	pterm.DefaultSection.Println("Real-time inverter information:")
-	pterm.DefaultTable.WithHasHeader().WithData(pterm.TableData{
-		{"Parameter", "Value", "Unit"},
+	data := pterm.TableData{{"Parameter", "Value", "Unit"}}
+	data = append(data, infoRows(*info)...)
+	data = append(data, []string{"Last update", time.Now().Format("2006-01-02 15:04:05"), ""})
+	pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+
+}
+
+// infoRows returns the parameter, value and unit of every field in the (normalized) info response
+func infoRows(info solax.NormalInfoResponse) [][]string {
+	nInfo := solax.NormalizeInfoResponse(info)
+	return [][]string{
 		{"Temperature", fmt.Sprintf("%d", nInfo.Temperature), "Celsius"},
 		{"EnergyToday", fmt.Sprintf("%.1f", nInfo.EnergyToday), "kWh"},
 		{"Vpv1", fmt.Sprintf("%.1f", nInfo.Vpv1), "Volt"},
@@ -199,25 +303,20 @@ func Info(cmd *cobra.Command, args []string) {
 		{"PV1Fault", fmt.Sprintf("%.1f", nInfo.PV1Fault), "Volt"},
 		{"PV2Fault", fmt.Sprintf("%.1f", nInfo.PV2Fault), "Volt"},
 		{"GFCFault", fmt.Sprintf("%.3f", nInfo.GFCFault), "A"},
-		{"ErrMessage", fmt.Sprintf("%s", strings.Join(nInfo.ErrMessage, ", ")), "-"},
-		{"Last update", time.Now().Format("2006-01-02 15:04:05"), ""},
-	}).Render()
-
-}
-func DeviceInfo(cmd *cobra.Command, args []string) {
-	if address < 0 || address > 255 {
-		log.Fatal("Address must be between 1-255")
+		{"ErrMessage", strings.Join(info.Faults().Describe(), ", "), "-"},
 	}
+}
 
-	client, err := solax.NewClient(device)
+func DeviceInfo(cmd *cobra.Command, args []string) {
+	inv := targetInverter()
+	client, err := newClient()
 	fatalIfError(err)
 
-	inv := &solax.Inverter{Address: byte(address)}
 	info, err := client.GetInverterInfo(inv)
 	fatalIfError(err)
 
 	if verbose {
-		log.Printf("Raw response: %X", client.LastResponse)
+		log.Printf("Raw response: %X", client.LastResponse())
 	}
 
 	if outputJson {
@@ -241,9 +340,184 @@ func DeviceInfo(cmd *cobra.Command, args []string) {
 		{"RatedBusVoltage", info.RatedBusVoltage, "V"},
 	}).Render()
 }
+func Config(cmd *cobra.Command, args []string) {
+	inv := targetInverter()
+	client, err := newClient()
+	fatalIfError(err)
+
+	config, err := client.GetConfig(inv)
+	if verbose {
+		log.Printf("Raw response: %X", client.LastResponse())
+	}
+	fatalIfError(err)
+
+	nConfig := solax.NormalizeConfigResponse(*config)
+	if outputJson {
+		out, err := json.Marshal(nConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+		os.Exit(0)
+	}
+
+	pterm.DefaultSection.Println("Inverter configuration:")
+	pterm.DefaultTable.WithHasHeader().WithData(pterm.TableData{
+		{"Parameter", "Value", "Unit"},
+		{"VpvStart", fmt.Sprintf("%.1f", nConfig.VpvStart), "Volt"},
+		{"TimeStart", fmt.Sprintf("%d", nConfig.TimeStart), "Seconds"},
+		{"VacMin", fmt.Sprintf("%.1f", nConfig.VacMin), "Volt"},
+		{"VacMax", fmt.Sprintf("%.1f", nConfig.VacMax), "Volt"},
+		{"FacMin", fmt.Sprintf("%.2f", nConfig.FacMin), "Hz"},
+		{"FacMax", fmt.Sprintf("%.2f", nConfig.FacMax), "Hz"},
+		{"DciLimit", fmt.Sprintf("%.3f", nConfig.DciLimit), "A"},
+		{"VacMinSlow", fmt.Sprintf("%.1f", nConfig.VacMinSlow), "Volt"},
+		{"VacMaxSlow", fmt.Sprintf("%.1f", nConfig.VacMaxSlow), "Volt"},
+		{"FacMinSlow", fmt.Sprintf("%.2f", nConfig.FacMinSlow), "Hz"},
+		{"FacMaxSlow", fmt.Sprintf("%.2f", nConfig.FacMaxSlow), "Hz"},
+		{"Vac10MinAvg", fmt.Sprintf("%.1f", nConfig.Vac10MinAvg), "Volt"},
+		{"ReconnectTime", fmt.Sprintf("%d", nConfig.ReconnectTime), "Seconds"},
+		{"PowerLimit", fmt.Sprintf("%d", nConfig.PowerLimit), "%"},
+		{"SafetyCountry", fmt.Sprintf("%d", nConfig.SafetyCountry), "-"},
+	}).Render()
+}
+func ConfigSet(cmd *cobra.Command, args []string) {
+	inv := targetInverter()
+	if inv.Address < 1 {
+		log.Fatal("Address must be between 1-255")
+	}
+
+	param, err := solax.ConfigParameterByName(args[0])
+	fatalIfError(err)
+	value, err := strconv.ParseFloat(args[1], 64)
+	fatalIfError(err)
+	raw, err := param.RawValue(value)
+	fatalIfError(err)
+
+	if dryRun {
+		req, err := solax.WriteConfigRequest(inv.Address, param, raw).Bytes()
+		fatalIfError(err)
+		fmt.Printf("%X\n", req)
+		os.Exit(0)
+	}
+
+	client, err := newClient()
+	fatalIfError(err)
+
+	changes, err := client.WriteConfig(inv, param, raw)
+	if verbose {
+		log.Printf("Raw response: %X", client.LastResponse())
+	}
+	fatalIfError(err)
+
+	if outputJson {
+		out, err := json.Marshal(changes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+		os.Exit(0)
+	}
+
+	if len(changes) == 0 {
+		log.Printf("Write of %s acknowledged, but no config values changed", param)
+		return
+	}
+	data := pterm.TableData{{"Parameter", "Before", "After"}}
+	for _, c := range changes {
+		data = append(data, []string{c.Field, fmt.Sprintf("%g", c.Before), fmt.Sprintf("%g", c.After)})
+	}
+	pterm.DefaultSection.Println("Changed configuration:")
+	pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
+
+func configKeys() string {
+	keys := []string{}
+	for _, p := range solax.ConfigParameters() {
+		keys = append(keys, p.String())
+	}
+	return strings.Join(keys, ", ")
+}
+
+var execActions = map[solax.ExecuteAction]struct {
+	use   string
+	short string
+}{
+	solax.ExecutePowerOn:  {"on", "Resume exporting power to the grid"},
+	solax.ExecutePowerOff: {"off", "Stop exporting power to the grid"},
+	solax.ExecuteRestart:  {"restart", "Restart the inverter"},
+	solax.ExecuteSelftest: {"selftest", "Start the inverter self-test"},
+}
+
+func newExecActionCmd(action solax.ExecuteAction) *cobra.Command {
+	return &cobra.Command{
+		Use:   execActions[action].use,
+		Short: execActions[action].short,
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			Exec(action)
+		},
+	}
+}
+
+func Exec(action solax.ExecuteAction) {
+	inv := targetInverter()
+	if inv.Address < 1 {
+		log.Fatal("Address must be between 1-255")
+	}
+	if !confirmed {
+		log.Fatalf("Refusing to execute %s without --yes", action)
+	}
+
+	client, err := newClient()
+	fatalIfError(err)
+
+	err = client.Execute(inv, action)
+	if verbose {
+		log.Printf("Raw response: %X", client.LastResponse())
+	}
+	fatalIfError(err)
+
+	log.Printf("Inverter with address %X acknowledged %s", inv.Address, action)
+}
+
+// newClient opens the device and applies the connection flags
+func newClient() (*solax.Client, error) {
+	var client *solax.Client
+	var err error
+	if strings.HasPrefix(device, "tcp://") {
+		client, err = solax.NewTCPClient(strings.TrimPrefix(device, "tcp://"))
+	} else {
+		client, err = solax.NewClient(device)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if capture != "" {
+		if captureFile, err = os.Create(capture); err != nil {
+			return nil, err
+		}
+		client.Conn = solax.NewCaptureConnection(client.Conn, captureFile)
+	}
+	client.RetryPolicy = solax.RetryPolicy{MaxAttempts: retries + 1, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	return client, nil
+}
+
+// closeCapture syncs and closes the --capture file, commands that exit with log.Fatal skip deferred calls
+func closeCapture() {
+	if captureFile == nil {
+		return
+	}
+	captureFile.Sync()
+	if err := captureFile.Close(); err != nil {
+		log.Printf("Closing capture %s: %s", capture, err)
+	}
+	captureFile = nil
+}
 
 func fatalIfError(err error) {
 	if err != nil {
+		closeCapture()
 		log.Fatal(err)
 	}
 }
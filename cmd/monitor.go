@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	monitorInterval     time.Duration
+	monitorAll          bool
+	monitorFaultHistory string // disabled by default, so monitor can run next to log
+)
+
+func init() {
+	monitorCmd.Flags().DurationVar(&monitorInterval, "interval", 10*time.Second, "Time between polls")
+	monitorCmd.Flags().BoolVar(&monitorAll, "all", false, "Monitor all inverters in the registry")
+	monitorCmd.Flags().StringVar(&invSerial, "serial", "", "Serial of the inverter to monitor, instead of --address (see 'bus list')")
+	monitorCmd.Flags().StringVar(&monitorFaultHistory, "fault-history", "", "Record fault episodes for 'faults' here (--fault-history without a value uses the default location)")
+	monitorCmd.Flags().Lookup("fault-history").NoOptDefVal = defaultFaultHistoryPath()
+	monitorCmd.Flags().StringVar(&alertConfig, "alerts", "", "Evaluate the alert rules and notifiers in this JSON file")
+	rootCmd.AddCommand(monitorCmd)
+}
+
+var monitorCmd = &cobra.Command{
+	Use:   "monitor",
+	Short: "Continuously poll real-time information until interrupted",
+	Run:   Monitor,
+}
+
+func Monitor(cmd *cobra.Command, args []string) {
+	inverters := monitoredInverters()
+	client, err := newClient()
+	fatalIfError(err)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	tracker := newFaultTracker(monitorFaultHistory)
+	alerts := newAlertQueue()
+	defer closeAlertQueue(alerts)
+	poller := solax.NewPoller(client, monitorInterval, inverters...)
+	if outputJson {
+		// one JSON object per line, e.g. to pipe into other tools
+		poller.Run(ctx, func(s solax.Sample) {
+			trackFaults(tracker, s, false)
+			evaluateAlerts(alerts, s, false)
+			out, err := json.Marshal(sampleJSON(s))
+			fatalIfError(err)
+			fmt.Println(string(out))
+		})
+		return
+	}
+
+	area, err := pterm.DefaultArea.Start()
+	fatalIfError(err)
+	defer area.Stop()
+
+	latest := map[byte]solax.Sample{}
+	poller.Run(ctx, func(s solax.Sample) {
+		latest[s.Inverter.Address] = s
+		trackFaults(tracker, s, verbose)
+		evaluateAlerts(alerts, s, verbose)
+		area.Update(monitorTable(inverters, latest))
+		if verbose && s.Err != nil {
+			log.Printf("Inverter %d: %s", s.Inverter.Address, s.Err)
+		}
+	})
+}
+
+// monitoredInverters returns all inverters in the registry with --all, or the one selected with --serial/--address
+func monitoredInverters() []solax.Inverter {
+	if !monitorAll {
+		return []solax.Inverter{*targetInverter()}
+	}
+	bus, err := solax.NewBus(nil, registry)
+	fatalIfError(err)
+	inverters := []solax.Inverter{}
+	for _, known := range bus.Inverters() {
+		inverters = append(inverters, *known.Inverter())
+	}
+	if len(inverters) == 0 {
+		log.Fatalf("No inverters in registry %s", registry)
+	}
+	return inverters
+}
+
+// monitorTable renders the latest sample of every inverter as a column in a table
+func monitorTable(inverters []solax.Inverter, latest map[byte]solax.Sample) string {
+	header := []string{"Parameter"}
+	columns := [][][]string{}
+	updated := []string{"Last update"}
+	for _, inv := range inverters {
+		header = append(header, fmt.Sprintf("Address %d", inv.Address))
+		s, ok := latest[inv.Address]
+		switch {
+		case !ok:
+			columns = append(columns, nil)
+			updated = append(updated, "waiting")
+		case s.Err != nil:
+			columns = append(columns, nil)
+			updated = append(updated, fmt.Sprintf("%s (error: %s)", s.Time.Format("15:04:05"), s.Err))
+		default:
+			columns = append(columns, infoRows(*s.Info))
+			updated = append(updated, s.Time.Format("15:04:05"))
+		}
+	}
+	header = append(header, "Unit")
+
+	data := pterm.TableData{header}
+	for i, row := range infoRows(solax.NormalInfoResponse{}) {
+		line := []string{row[0]}
+		for _, c := range columns {
+			if c == nil {
+				line = append(line, "-")
+				continue
+			}
+			line = append(line, c[i][1])
+		}
+		data = append(data, append(line, row[2]))
+	}
+	data = append(data, append(updated, ""))
+
+	table, err := pterm.DefaultTable.WithHasHeader().WithData(data).Srender()
+	if err != nil {
+		return err.Error()
+	}
+	return pterm.DefaultSection.Sprint("Real-time inverter information:") + table
+}
+
+func sampleJSON(s solax.Sample) interface{} {
+	out := struct {
+		Time    time.Time
+		Address byte
+		Info    *solax.NormalizedNormalInfoResponse `json:",omitempty"`
+		Error   string                              `json:",omitempty"`
+	}{Time: s.Time, Address: s.Inverter.Address}
+	if s.Err != nil {
+		out.Error = s.Err.Error()
+	} else {
+		n := solax.NormalizeInfoResponse(*s.Info)
+		out.Info = &n
+	}
+	return out
+}
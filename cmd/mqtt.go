@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mqttBroker          string
+	mqttClientID        string
+	mqttUsername        string
+	mqttPassword        string
+	mqttTopicPrefix     string
+	mqttDiscoveryPrefix string
+)
+
+func init() {
+	mqttCmd.Flags().StringVar(&mqttBroker, "broker", "tcp://localhost:1883", "MQTT broker to publish to")
+	mqttCmd.Flags().StringVar(&mqttClientID, "client-id", "solax", "MQTT client ID")
+	mqttCmd.Flags().StringVar(&mqttUsername, "username", "", "MQTT username")
+	mqttCmd.Flags().StringVar(&mqttPassword, "password", "", "MQTT password, requires --username")
+	mqttCmd.Flags().StringVar(&mqttTopicPrefix, "topic-prefix", "solax", "Prefix of the state and availability topics")
+	mqttCmd.Flags().StringVar(&mqttDiscoveryPrefix, "discovery-prefix", "homeassistant", "Home Assistant discovery prefix, empty to disable discovery")
+	mqttCmd.Flags().DurationVar(&monitorInterval, "interval", 10*time.Second, "Time between polls")
+	mqttCmd.Flags().BoolVar(&monitorAll, "all", false, "Publish all inverters in the registry")
+	mqttCmd.Flags().StringVar(&invSerial, "serial", "", "Serial of the inverter to publish, instead of --address (see 'bus list')")
+	rootCmd.AddCommand(mqttCmd)
+}
+
+var mqttCmd = &cobra.Command{
+	Use:   "mqtt",
+	Short: "Continuously publish real-time information to MQTT, with Home Assistant discovery",
+	Run:   MQTT,
+}
+
+func MQTT(cmd *cobra.Command, args []string) {
+	if mqttPassword != "" && mqttUsername == "" {
+		log.Fatal("--password requires --username")
+	}
+	inverters := monitoredInverters()
+	client, err := newClient()
+	fatalIfError(err)
+
+	m := solax.NewMQTTClient(mqttBroker, mqttClientID)
+	m.Username = mqttUsername
+	m.Password = mqttPassword
+	publisher := solax.NewMQTTPublisher(m, mqttTopicPrefix, mqttDiscoveryPrefix)
+	defer publisher.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	devices := knownDevices()
+	solax.NewPoller(client, monitorInterval, inverters...).Run(ctx, func(s solax.Sample) {
+		// the topics are based on the registry or the address, so they don't change when the inverter is off at startup
+		device, ok := devices[s.Inverter.Address]
+		if !ok {
+			device = solax.KnownInverter{Serial: s.Inverter.Serial, Address: s.Inverter.Address}
+		}
+		if device.Model == "" && s.Err == nil {
+			// the model and firmware are shown in Home Assistant, query them once the inverter answers
+			if info, err := client.GetInverterInfoContext(ctx, &s.Inverter); err != nil {
+				log.Printf("Inverter %d: %s", s.Inverter.Address, err)
+			} else {
+				device.Model, device.FirmwareVersion = strings.TrimSpace(info.ModuleName), strings.TrimSpace(info.FirmwareVersion)
+			}
+		}
+		devices[s.Inverter.Address] = device
+		if verbose && s.Err != nil {
+			log.Printf("Inverter %d: %s", s.Inverter.Address, s.Err)
+		}
+		if err := publisher.Publish(device, s); err != nil {
+			log.Printf("Publishing to %s: %s", mqttBroker, err)
+		}
+	})
+}
+
+// knownDevices returns the inverters in the registry by address
+func knownDevices() map[byte]solax.KnownInverter {
+	bus, err := solax.NewBus(nil, registry)
+	fatalIfError(err)
+	devices := map[byte]solax.KnownInverter{}
+	for _, known := range bus.Inverters() {
+		devices[known.Address] = known
+	}
+	return devices
+}
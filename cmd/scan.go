@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scanRange   string
+	scanTimeout time.Duration
+)
+
+func init() {
+	scanCmd.Flags().StringVar(&scanRange, "range", "1-255", "Range of addresses to probe, e.g. 1-32")
+	scanCmd.Flags().DurationVar(&scanTimeout, "timeout", 200*time.Millisecond, "Time to wait for a response from each address")
+	rootCmd.AddCommand(scanCmd)
+}
+
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Probe a range of bus addresses for registered inverters",
+	Run:   Scan,
+}
+
+func Scan(cmd *cobra.Command, args []string) {
+	from, to, err := parseAddressRange(scanRange)
+	fatalIfError(err)
+
+	client, err := newClient()
+	fatalIfError(err)
+
+	opts := solax.ScanOptions{Timeout: scanTimeout}
+	var bar *pterm.ProgressbarPrinter
+	if !outputJson {
+		bar, err = pterm.DefaultProgressbar.WithTotal(int(to) - int(from) + 1).WithTitle("Scanning addresses").Start()
+		fatalIfError(err)
+		opts.Progress = func(address byte, result *solax.ScanResult) {
+			bar.UpdateTitle(fmt.Sprintf("Scanning address %d", address))
+			bar.Increment()
+		}
+	}
+	results, err := client.ScanAddresses(context.Background(), from, to, opts)
+	if bar != nil {
+		bar.Stop()
+	}
+	fatalIfError(err)
+
+	if outputJson {
+		out, err := json.Marshal(results)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	if len(results) == 0 {
+		log.Printf("No inverters found at addresses %d-%d", from, to)
+		return
+	}
+	data := pterm.TableData{{"Address", "SerialNumber", "Model", "FirmwareVersion", "RatedPower"}}
+	for _, r := range results {
+		if r.Garbled {
+			data = append(data, []string{fmt.Sprintf("%d", r.Address), "(garbled answer, several inverters at this address?)", "", "", ""})
+			continue
+		}
+		data = append(data, []string{
+			fmt.Sprintf("%d", r.Address),
+			strings.TrimSpace(r.Info.SerialNumber),
+			strings.TrimSpace(r.Info.ModuleName),
+			strings.TrimSpace(r.Info.FirmwareVersion),
+			strings.TrimSpace(r.Info.RatedPower),
+		})
+	}
+	pterm.DefaultSection.Println("Inverters on the bus:")
+	pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
+
+// parseAddressRange parses "from-to" (or a single address) into bus addresses
+func parseAddressRange(in string) (byte, byte, error) {
+	parts := strings.SplitN(in, "-", 2)
+	from, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range %q: %w", in, err)
+	}
+	to := from
+	if len(parts) == 2 {
+		to, err = strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 8)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid range %q: %w", in, err)
+		}
+	}
+	if from < 1 || to < from {
+		return 0, 0, fmt.Errorf("invalid range %q: addresses must be between 1-255 and ascending", in)
+	}
+	return byte(from), byte(to), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/hectormalot/solax-x1-rs485/simulator"
+	"github.com/spf13/cobra"
+)
+
+var (
+	simulatePTY    bool
+	simulateListen string
+	simulateSerial string
+	simulateState  string
+)
+
+func init() {
+	simulateCmd.Flags().BoolVar(&simulatePTY, "pty", false, "Create a pseudo-terminal to use as --device (Linux only)")
+	simulateCmd.Flags().StringVar(&simulateListen, "listen", "", "Listen on this TCP address, to use as --device tcp://host:port")
+	simulateCmd.Flags().StringVar(&simulateSerial, "serial", "XB3002I0000001", "Serial of the simulated inverter")
+	simulateCmd.Flags().StringVar(&simulateState, "state", "", "JSON file with the state of the simulated inverter (Serial, Address, Info, Spec, Config and Script), overrides --serial and --address")
+	rootCmd.AddCommand(simulateCmd)
+}
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Simulate an inverter on a pseudo-terminal or TCP port, to try the other commands without hardware",
+	Long: `Simulate an inverter on a pseudo-terminal or TCP port, to try the other commands without hardware.
+The inverter starts unregistered, unless --address is given.`,
+	Run: Simulate,
+}
+
+func Simulate(cmd *cobra.Command, args []string) {
+	if !simulatePTY && simulateListen == "" {
+		log.Fatal("Use --pty and/or --listen")
+	}
+	inv := simulator.NewInverter(simulateSerial, byte(address))
+	if simulateState != "" {
+		body, err := os.ReadFile(simulateState)
+		fatalIfError(err)
+		fatalIfError(json.Unmarshal(body, inv))
+	}
+
+	errs := make(chan error, 2)
+	if simulatePTY {
+		pty, err := simulator.OpenPTY()
+		fatalIfError(err)
+		defer pty.Close()
+		log.Printf("Simulating inverter %s on %s, e.g. 'solax -d %s find'", inv.Serial, pty.Name, pty.Name)
+		go func() { errs <- inv.ServeConn(pty) }()
+	}
+	if simulateListen != "" {
+		l, err := net.Listen("tcp", simulateListen)
+		fatalIfError(err)
+		defer l.Close()
+		log.Printf("Simulating inverter %s on tcp://%s", inv.Serial, l.Addr())
+		go func() { errs <- inv.Serve(l) }()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	select {
+	case <-ctx.Done():
+	case err := <-errs:
+		log.Print(err)
+	}
+}
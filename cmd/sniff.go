@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/spf13/cobra"
+)
+
+var sniffOutput string
+
+func init() {
+	sniffCmd.Flags().StringVar(&sniffOutput, "output", "", "Also append the frames to this file")
+	rootCmd.AddCommand(sniffCmd)
+}
+
+var sniffCmd = &cobra.Command{
+	Use:   "sniff",
+	Short: "Show the frames on the bus without transmitting, e.g. what a Pocket WiFi dongle asks the inverters",
+	Long: `Show the frames on the bus without transmitting, e.g. what a Pocket WiFi dongle asks the inverters.
+Every frame is printed with a timestamp, the direction (> to, < from the inverter), the address and its interpretation.
+Use -v to include the raw frame, --json for one JSON object per frame.`,
+	Run: Sniff,
+}
+
+func Sniff(cmd *cobra.Command, args []string) {
+	client, err := newClient()
+	fatalIfError(err)
+	defer client.Conn.Close()
+
+	var out io.Writer = os.Stdout
+	if sniffOutput != "" {
+		f, err := os.OpenFile(sniffOutput, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		fatalIfError(err)
+		defer f.Close()
+		out = io.MultiWriter(os.Stdout, f)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	sniffer := solax.NewSniffer(client.Conn)
+	for {
+		frame, err := sniffer.Next(ctx)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		fatalIfError(err)
+
+		if outputJson {
+			line, err := json.Marshal(frameJSON(frame))
+			fatalIfError(err)
+			fmt.Fprintln(out, string(line))
+			continue
+		}
+		if verbose {
+			fmt.Fprintf(out, "%s\n%s  %X\n", frame, frame.Time.Local().Format("15:04:05.000"), frame.Raw)
+			continue
+		}
+		fmt.Fprintln(out, frame)
+	}
+}
+
+func frameJSON(f solax.DecodedFrame) interface{} {
+	out := struct {
+		Time         time.Time
+		Raw          string
+		Source       *uint16 `json:",omitempty"`
+		Destination  *uint16 `json:",omitempty"`
+		ControlCode  *byte   `json:",omitempty"`
+		FunctionCode *byte   `json:",omitempty"`
+		Description  string
+		Error        string `json:",omitempty"`
+	}{Time: f.Time, Raw: fmt.Sprintf("%X", f.Raw), Description: f.Description}
+	if f.Err != nil {
+		out.Error = f.Err.Error()
+	}
+	if p := f.Packet; p != nil {
+		out.Source, out.Destination, out.ControlCode, out.FunctionCode = &p.Source, &p.Destination, &p.ControlCode, &p.FunctionCode
+	}
+	return out
+}
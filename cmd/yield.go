@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	yieldPeriod string
+	yieldFrom   string
+	yieldTo     string
+	yieldStore  string
+)
+
+func init() {
+	yieldCmd.Flags().StringVar(&yieldStore, "store", defaultStorePath(), "Location of the store written by 'log --store'")
+	yieldCmd.Flags().StringVar(&yieldPeriod, "period", "day", "Report the yield per day, month or year (local time)")
+	yieldCmd.Flags().StringVar(&yieldFrom, "from", "", "Start of the report (same formats as 'history --from'), defaults to the first sample")
+	yieldCmd.Flags().StringVar(&yieldTo, "to", "", "End of the report, defaults to now")
+	rootCmd.AddCommand(yieldCmd)
+}
+
+var yieldCmd = &cobra.Command{
+	Use:   "yield",
+	Short: "Report the energy produced per day, month or year from the local store",
+	Run:   YieldReport,
+}
+
+func YieldReport(cmd *cobra.Command, args []string) {
+	period, err := solax.ParseYieldPeriod(yieldPeriod)
+	fatalIfError(err)
+	now := time.Now()
+	var from, to time.Time
+	if yieldFrom != "" {
+		from, err = parseTime(yieldFrom, now)
+		fatalIfError(err)
+	}
+	if yieldTo != "" {
+		to, err = parseTime(yieldTo, now)
+		fatalIfError(err)
+	}
+
+	store, err := solax.OpenStoreReadOnly(yieldStore)
+	fatalIfError(err)
+	defer store.Close()
+	records, err := store.Records(from, to)
+	fatalIfError(err)
+
+	account := solax.NewYieldAccount(period, time.Local)
+	account.AddRecords(records)
+	yields := account.Yields()
+
+	if outputJson {
+		out, err := json.Marshal(yields)
+		fatalIfError(err)
+		fmt.Println(string(out))
+		return
+	}
+	if len(yields) == 0 {
+		log.Print("No samples in the store for this period")
+		return
+	}
+
+	layout := map[solax.YieldPeriod]string{solax.YieldDay: "2006-01-02", solax.YieldMonth: "2006-01", solax.YieldYear: "2006"}[period]
+	data := pterm.TableData{{"Period", "Address", "Energy (kWh)", "Estimated (kWh)", "Samples"}}
+	totals := map[byte]float64{}
+	for _, y := range yields {
+		totals[y.Address] += y.Energy
+		data = append(data, []string{y.Start.Format(layout), strconv.Itoa(int(y.Address)), fmt.Sprintf("%.1f", y.Energy), fmt.Sprintf("%.1f", y.Estimated), strconv.Itoa(y.Samples)})
+	}
+	for address := 0; address <= 255; address++ {
+		if total, ok := totals[byte(address)]; ok {
+			data = append(data, []string{"Total", strconv.Itoa(address), fmt.Sprintf("%.1f", total), "", ""})
+		}
+	}
+	pterm.DefaultSection.Printf("Energy yield per %s:", period)
+	pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
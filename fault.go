@@ -0,0 +1,211 @@
+package solaxx1rs485
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FaultCode is the ErrMessage bitmask of NormalInfoResponse. Bit 0 of the documented
+// table (see "ErrMessage details" in protocol.go) is the most significant bit.
+type FaultCode uint32
+
+const (
+	FaultTzProtect FaultCode = 1 << (31 - iota) // bit 0
+	FaultMainsLost
+	FaultGridVolt
+	FaultGridFreq
+	FaultPLLLost
+	FaultBusVolt
+	FaultBit06
+	FaultOci
+	FaultDciOCP // bit 8
+	FaultResidualCurrent
+	FaultPvVolt
+	FaultAc10MinsVoltage
+	FaultIsolation
+	FaultTemperatureOver
+	FaultFan
+	FaultBit15
+	FaultSpiComms // bit 16
+	FaultSciComms
+	FaultBit18
+	FaultInputConfig
+	FaultEeprom
+	FaultRelay
+	FaultSampleConsistence
+	FaultResidualCurrentDevice
+	FaultBit24 // bit 24
+	FaultBit25
+	FaultBit26
+	FaultBit27
+	FaultBit28
+	FaultDCIDevice
+	FaultOtherDevice
+	FaultBit31
+)
+
+// FaultCategory tells where the cause of a fault is
+type FaultCategory int
+
+const (
+	FaultCategoryUnknown  FaultCategory = iota // Undocumented bits
+	FaultCategoryGrid                          // Grid out of range or lost, the inverter reconnects when the grid recovers
+	FaultCategoryPV                            // PV strings or their wiring
+	FaultCategoryHardware                      // Internal hardware of the inverter
+)
+
+func (c FaultCategory) String() string {
+	switch c {
+	case FaultCategoryGrid:
+		return "grid"
+	case FaultCategoryPV:
+		return "pv"
+	case FaultCategoryHardware:
+		return "hardware"
+	}
+	return "unknown"
+}
+
+// FaultSeverity tells how much attention a fault needs, higher is more severe
+type FaultSeverity int
+
+const (
+	FaultWarning  FaultSeverity = iota + 1 // External condition, clears by itself
+	FaultError                             // Needs to be looked at when it persists
+	FaultCritical                          // Internal hardware failure, needs service
+)
+
+func (s FaultSeverity) String() string {
+	switch s {
+	case FaultWarning:
+		return "warning"
+	case FaultError:
+		return "error"
+	case FaultCritical:
+		return "critical"
+	}
+	return "none"
+}
+
+type faultInfo struct {
+	name     string
+	category FaultCategory
+	severity FaultSeverity
+}
+
+// faults describes every bit, in the order of the documented table (bit 0 first)
+var faults = [32]faultInfo{
+	{"TzProtectFault", FaultCategoryHardware, FaultError},
+	{"MainsLostFault", FaultCategoryGrid, FaultWarning},
+	{"GridVoltFault", FaultCategoryGrid, FaultWarning},
+	{"GridFreqFault", FaultCategoryGrid, FaultWarning},
+	{"PLLLostFault", FaultCategoryGrid, FaultWarning},
+	{"BusVoltFault", FaultCategoryHardware, FaultError},
+	{"BIT06", FaultCategoryUnknown, FaultError},
+	{"OciFault", FaultCategoryHardware, FaultError},
+	{"Dci_OCP_Fault", FaultCategoryHardware, FaultError},
+	{"ResidualCurrentFault", FaultCategoryPV, FaultError},
+	{"PvVoltFault", FaultCategoryPV, FaultError},
+	{"Ac10Mins_Voltage_Fault", FaultCategoryGrid, FaultWarning},
+	{"IsolationFault", FaultCategoryPV, FaultError},
+	{"TemperatureOverFault", FaultCategoryHardware, FaultError},
+	{"FanFault", FaultCategoryHardware, FaultError},
+	{"bit15", FaultCategoryUnknown, FaultError},
+	{"SpiCommsFault", FaultCategoryHardware, FaultCritical},
+	{"SciCommsFault", FaultCategoryHardware, FaultCritical},
+	{"BIT18", FaultCategoryUnknown, FaultError},
+	{"InputConfigFault", FaultCategoryPV, FaultError},
+	{"EepromFault", FaultCategoryHardware, FaultCritical},
+	{"RelayFault", FaultCategoryHardware, FaultCritical},
+	{"SampleConsistenceFault", FaultCategoryHardware, FaultCritical},
+	{"ResidualCurrent_DeviceFault", FaultCategoryHardware, FaultCritical},
+	{"BIT24", FaultCategoryUnknown, FaultError},
+	{"BIT25", FaultCategoryUnknown, FaultError},
+	{"BIT26", FaultCategoryUnknown, FaultError},
+	{"BIT27", FaultCategoryUnknown, FaultError},
+	{"BIT28", FaultCategoryUnknown, FaultError},
+	{"DCI_DeviceFault", FaultCategoryHardware, FaultCritical},
+	{"OtherDeviceFault", FaultCategoryHardware, FaultCritical},
+	{"BIT31", FaultCategoryUnknown, FaultError},
+}
+
+// AllFaults returns every single-bit fault, in the order of the documented table
+func AllFaults() []FaultCode {
+	return FaultCode(0xFFFFFFFF).List()
+}
+
+// Has reports whether all bits of f are set
+func (c FaultCode) Has(f FaultCode) bool {
+	return c&f == f
+}
+
+// List returns the single-bit faults that are set, in the order of the documented table
+func (c FaultCode) List() []FaultCode {
+	list := []FaultCode{}
+	for bit := 0; bit < 32; bit++ {
+		if f := FaultCode(1) << (31 - bit); c&f != 0 {
+			list = append(list, f)
+		}
+	}
+	return list
+}
+
+// Names returns the names of the faults that are set, as in NormalizedNormalInfoResponse.ErrMessage
+func (c FaultCode) Names() []string {
+	names := []string{}
+	for _, f := range c.List() {
+		names = append(names, faults[f.Bit()].name)
+	}
+	return names
+}
+
+// String returns the names of the faults that are set, separated by ", ", or "None"
+func (c FaultCode) String() string {
+	if c == 0 {
+		return "None"
+	}
+	return strings.Join(c.Names(), ", ")
+}
+
+// Bit returns the bit number in the documented table of the most significant fault that is set, -1 when none is set
+func (c FaultCode) Bit() int {
+	for bit := 0; bit < 32; bit++ {
+		if c&(FaultCode(1)<<(31-bit)) != 0 {
+			return bit
+		}
+	}
+	return -1
+}
+
+// Category returns the category of a single fault. With multiple bits set, the first fault in the table is used.
+func (c FaultCode) Category() FaultCategory {
+	if c == 0 {
+		return FaultCategoryUnknown
+	}
+	return faults[c.Bit()].category
+}
+
+// Severity returns the highest severity of the faults that are set, 0 when none is set
+func (c FaultCode) Severity() FaultSeverity {
+	severity := FaultSeverity(0)
+	for _, f := range c.List() {
+		if s := faults[f.Bit()].severity; s > severity {
+			severity = s
+		}
+	}
+	return severity
+}
+
+// Describe returns the names with category and severity, e.g. "GridVoltFault (grid, warning)"
+func (c FaultCode) Describe() []string {
+	descriptions := []string{}
+	for _, f := range c.List() {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%s, %s)", f, f.Category(), f.Severity()))
+	}
+	return descriptions
+}
+
+// Faults returns the ErrMessage bitmask as a FaultCode
+func (r NormalInfoResponse) Faults() FaultCode {
+	return FaultCode(r.ErrMessage)
+}
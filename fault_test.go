@@ -0,0 +1,41 @@
+package solaxx1rs485
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultCode(t *testing.T) {
+	require.Equal(t, FaultCode(1<<31), FaultTzProtect)
+	require.Equal(t, FaultCode(1<<(31-13)), FaultTemperatureOver)
+	require.Equal(t, FaultCode(1), FaultBit31)
+	require.Len(t, AllFaults(), 32)
+
+	c := NormalInfoResponse{ErrMessage: 0b00110000_00000000_00000100_00000000}.Faults()
+	require.True(t, c.Has(FaultGridVolt))
+	require.True(t, c.Has(FaultGridVolt|FaultGridFreq))
+	require.False(t, c.Has(FaultGridVolt|FaultFan))
+	require.Equal(t, []FaultCode{FaultGridVolt, FaultGridFreq, FaultRelay}, c.List())
+	require.Equal(t, []string{"GridVoltFault", "GridFreqFault", "RelayFault"}, c.Names())
+	require.Equal(t, "GridVoltFault, GridFreqFault, RelayFault", c.String())
+	require.Equal(t, "None", FaultCode(0).String())
+	require.Empty(t, FaultCode(0).List())
+
+	require.Equal(t, 2, c.Bit())
+	require.Equal(t, 21, FaultRelay.Bit())
+	require.Equal(t, -1, FaultCode(0).Bit())
+
+	require.Equal(t, FaultCategoryGrid, FaultMainsLost.Category())
+	require.Equal(t, FaultCategoryPV, FaultIsolation.Category())
+	require.Equal(t, FaultCategoryHardware, FaultEeprom.Category())
+	require.Equal(t, FaultCategoryUnknown, FaultBit06.Category())
+	require.Equal(t, FaultWarning, FaultGridVolt.Severity())
+	require.Equal(t, FaultCritical, c.Severity())
+	require.Equal(t, FaultSeverity(0), FaultCode(0).Severity())
+	require.Equal(t, []string{"GridVoltFault (grid, warning)", "GridFreqFault (grid, warning)", "RelayFault (hardware, critical)"}, c.Describe())
+
+	n := NormalizeInfoResponse(NormalInfoResponse{ErrMessage: uint32(FaultPvVolt | FaultFan)})
+	require.Equal(t, FaultPvVolt|FaultFan, n.Faults)
+	require.Equal(t, []string{"PvVoltFault", "FanFault"}, n.ErrMessage)
+}
@@ -0,0 +1,180 @@
+package solaxx1rs485
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// idleDelay is the time to wait before reading again when the reader
+// returned no data (e.g. a serial port with a read timeout returns io.EOF)
+const idleDelay = 5 * time.Millisecond
+
+/*
+FrameReader reads complete packets from a stream of bytes.
+
+It syncs on the 0xAA55 header, reads the data length at offset 8 and returns
+as soon as the full packet (data length + 11 bytes) has been received. Bytes
+received after a packet are kept for the next call, so two packets arriving
+in a single read are both returned.
+
+A 0xAA55 in line noise looks like a header as well. When such a frame fails the
+checksum, only the bytes up to the next 0xAA55 in it are dropped, and a valid
+packet following an incomplete frame is returned without waiting for the rest.
+*/
+type FrameReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: r}
+}
+
+// ReadFrame returns the next packet in the stream. When the checksum of the packet does not match,
+// the packet is returned together with an error wrapping ErrInvalidBody. The packet then ends at the
+// next 0xAA55 in it, the bytes from there are read again by the next call.
+//
+// If no complete packet has been received before the deadline, the bytes received so far are returned
+// (this may be an empty slice) without an error, ParsePacket will report what is wrong with them.
+func (f *FrameReader) ReadFrame(deadline time.Time) ([]byte, error) {
+	return f.ReadFrameContext(context.Background(), deadline)
+}
+
+// ReadFrameContext is like ReadFrame, but returns ctx.Err() when ctx is done before a packet is complete.
+// The deadline of ctx (if any) ends the read when it is earlier than deadline.
+func (f *FrameReader) ReadFrameContext(ctx context.Context, deadline time.Time) ([]byte, error) {
+	ctxDeadline := false
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+		ctxDeadline = true
+	}
+	tmp := make([]byte, 256)
+	for {
+		if frame, ok := f.next(); ok {
+			err := validateFrame(frame)
+			if err != nil {
+				if i := resync(frame); i > 0 {
+					f.unread(frame[i:])
+					frame = frame[:i]
+				}
+			}
+			return frame, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			if ctxDeadline {
+				return nil, context.DeadlineExceeded
+			}
+			partial := f.buf
+			f.buf = nil
+			if partial == nil {
+				partial = []byte{}
+			}
+			return partial, nil
+		}
+
+		n, err := f.r.Read(tmp)
+		f.buf = append(f.buf, tmp[:n]...)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if n == 0 {
+			time.Sleep(idleDelay)
+		}
+	}
+}
+
+// Reset discards all buffered bytes
+func (f *FrameReader) Reset() {
+	f.buf = nil
+}
+
+// unread puts b back in front of the buffered bytes
+func (f *FrameReader) unread(b []byte) {
+	f.buf = append(append([]byte{}, b...), f.buf...)
+}
+
+// buffered reports whether bytes are left over from the previous frames
+func (f *FrameReader) buffered() bool {
+	return len(f.buf) > 0
+}
+
+// next removes and returns the first complete packet from the buffer.
+// Bytes before the first 0xAA55 header are dropped.
+func (f *FrameReader) next() ([]byte, bool) {
+	start := -1
+	for i := 0; i+1 < len(f.buf); i++ {
+		if f.buf[i] == 0xAA && f.buf[i+1] == 0x55 {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		// keep a trailing 0xAA, it may be the start of a header
+		if len(f.buf) > 0 && f.buf[len(f.buf)-1] == 0xAA {
+			f.buf = f.buf[len(f.buf)-1:]
+		} else {
+			f.buf = nil
+		}
+		return nil, false
+	}
+	f.buf = f.buf[start:]
+
+	size := frameSize(f.buf)
+	if size < 0 || len(f.buf) < size {
+		// don't wait for the rest of a false header when a valid packet follows it
+		if i := validFrameAfter(f.buf); i > 0 {
+			f.buf = f.buf[i:]
+			return f.next()
+		}
+		return nil, false
+	}
+	frame := append([]byte{}, f.buf[:size]...)
+	f.buf = f.buf[size:]
+	return frame, true
+}
+
+// frameSize returns the size of the packet starting at b[0], -1 when its length is not in yet
+func frameSize(b []byte) int {
+	if len(b) < 9 {
+		return -1
+	}
+	return int(b[8]) + 11
+}
+
+// validFrameAfter returns the position of the first complete packet with a valid checksum after b[0], 0 when there is none
+func validFrameAfter(b []byte) int {
+	for i := 2; i+1 < len(b); i++ {
+		if b[i] != 0xAA || b[i+1] != 0x55 {
+			continue
+		}
+		size := frameSize(b[i:])
+		if size >= 0 && len(b)-i >= size && validateFrame(b[i:i+size]) == nil {
+			return i
+		}
+	}
+	return 0
+}
+
+// resync returns the position of the next header in an invalid frame, including a 0xAA at the very end, 0 when there is none
+func resync(frame []byte) int {
+	for i := 2; i < len(frame); i++ {
+		if frame[i] == 0xAA && (i+1 == len(frame) || frame[i+1] == 0x55) {
+			return i
+		}
+	}
+	return 0
+}
+
+func validateFrame(frame []byte) error {
+	cs := uint16FromBytes(*(*[2]byte)(frame[len(frame)-2:]))
+	if checksum(frame[:len(frame)-2]) != cs {
+		return fmt.Errorf("%w: checksum mismatch: Packet specifies checksum of %X, got %X instead", ErrInvalidBody, cs, checksum(frame[:len(frame)-2]))
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package solaxx1rs485
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// chunkReader returns one chunk per Read call, and io.EOF when no chunks are left
+type chunkReader struct {
+	chunks [][]byte
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, c.chunks[0])
+	c.chunks[0] = c.chunks[0][n:]
+	if len(c.chunks[0]) == 0 {
+		c.chunks = c.chunks[1:]
+	}
+	return n, nil
+}
+
+func testFrame(t *testing.T, functionCode byte, data []byte) []byte {
+	p := DefaultPacket()
+	p.ControlCode = ControlCodeRead
+	p.FunctionCode = functionCode
+	p.Data = data
+	body, err := p.Bytes()
+	require.NoError(t, err)
+	return body
+}
+
+func TestFrameReader(t *testing.T) {
+	deadline := func() time.Time { return time.Now().Add(100 * time.Millisecond) }
+
+	t.Run("Frame split across reads", func(t *testing.T) {
+		frame := testFrame(t, 0x82, []byte{1, 2, 3, 4})
+		f := NewFrameReader(&chunkReader{chunks: [][]byte{frame[:3], frame[3:10], frame[10:]}})
+		got, err := f.ReadFrame(deadline())
+		require.NoError(t, err)
+		require.Equal(t, frame, got)
+	})
+
+	t.Run("Two frames in one read", func(t *testing.T) {
+		first := testFrame(t, 0x82, []byte{1})
+		second := testFrame(t, 0x83, []byte{2, 3})
+		f := NewFrameReader(&chunkReader{chunks: [][]byte{append(append([]byte{}, first...), second...)}})
+		got, err := f.ReadFrame(deadline())
+		require.NoError(t, err)
+		require.Equal(t, first, got)
+		got, err = f.ReadFrame(deadline())
+		require.NoError(t, err)
+		require.Equal(t, second, got)
+	})
+
+	t.Run("Garbage before header is skipped", func(t *testing.T) {
+		frame := testFrame(t, 0x82, []byte{1, 2})
+		f := NewFrameReader(&chunkReader{chunks: [][]byte{{0x00, 0x12, 0xAA}, {0x01, 0xAA}, frame}})
+		got, err := f.ReadFrame(deadline())
+		require.NoError(t, err)
+		require.Equal(t, frame, got)
+	})
+
+	t.Run("Checksum mismatch", func(t *testing.T) {
+		frame := testFrame(t, 0x82, []byte{1, 2})
+		frame[len(frame)-1]++
+		f := NewFrameReader(&chunkReader{chunks: [][]byte{frame}})
+		got, err := f.ReadFrame(deadline())
+		require.ErrorIs(t, err, ErrInvalidBody)
+		require.Equal(t, frame, got)
+	})
+
+	t.Run("False header in line noise", func(t *testing.T) {
+		frame := testFrame(t, 0x82, []byte{1, 2})
+		noise := []byte{0xAA, 0x55, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x08, 0x07}
+		f := NewFrameReader(&chunkReader{chunks: [][]byte{noise, frame, testFrame(t, 0x83, make([]byte, 10))}})
+		got, err := f.ReadFrame(deadline())
+		require.ErrorIs(t, err, ErrInvalidBody)
+		require.Equal(t, noise, got)
+		got, err = f.ReadFrame(deadline())
+		require.NoError(t, err)
+		require.Equal(t, frame, got)
+	})
+
+	t.Run("False header does not wait for its length", func(t *testing.T) {
+		frame := testFrame(t, 0x82, []byte{1, 2})
+		noise := []byte{0xAA, 0x55, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0xF0}
+		f := NewFrameReader(&chunkReader{chunks: [][]byte{noise, frame}})
+		start := time.Now()
+		got, err := f.ReadFrame(start.Add(time.Second))
+		require.NoError(t, err)
+		require.Equal(t, frame, got)
+		require.Less(t, time.Since(start), 100*time.Millisecond)
+	})
+
+	t.Run("Incomplete frame returned at deadline", func(t *testing.T) {
+		frame := testFrame(t, 0x82, []byte{1, 2})
+		f := NewFrameReader(&chunkReader{chunks: [][]byte{frame[:8]}})
+		got, err := f.ReadFrame(deadline())
+		require.NoError(t, err)
+		require.Equal(t, frame[:8], got)
+		_, err = ParsePacket(got)
+		require.ErrorIs(t, err, ErrInvalidBody)
+	})
+
+	t.Run("Nothing received", func(t *testing.T) {
+		f := NewFrameReader(&chunkReader{})
+		start := time.Now()
+		got, err := f.ReadFrame(start.Add(20 * time.Millisecond))
+		require.NoError(t, err)
+		require.Empty(t, got)
+		require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	})
+
+	t.Run("Returns as soon as frame is complete", func(t *testing.T) {
+		frame := testFrame(t, 0x82, []byte{1, 2})
+		f := NewFrameReader(&chunkReader{chunks: [][]byte{frame}})
+		start := time.Now()
+		_, err := f.ReadFrame(start.Add(time.Second))
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), 100*time.Millisecond)
+	})
+}
@@ -0,0 +1,422 @@
+package solaxx1rs485
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMQTTConnectionRefused       = errors.New("MQTT broker refused the connection")
+	ErrMQTTPasswordWithoutUsername = errors.New("MQTT password set without a username")
+)
+
+/*
+MQTTClient publishes messages to an MQTT 3.1.1 broker.
+
+Only what is needed to push inverter data is implemented: QoS 0 publishes
+(optionally retained), a last will, username/password authentication and
+keep alive pings. That is all MQTTPublisher uses, and as every state topic is
+retained, a publish lost with QoS 0 is replaced by the next poll. It doesn't
+warrant a full client library and its dependencies.
+
+Like TCPConnection, the connection is made lazily and a broken connection is
+dialed again on the next Publish. After a failed connect, Publish fails right
+away until ReconnectDelay has passed, the delay doubles (up to a minute) while
+the broker stays unreachable.
+*/
+type MQTTClient struct {
+	Broker         string // host:port, optionally prefixed with tcp:// or mqtt://
+	ClientID       string
+	Username       string
+	Password       string // Requires Username (MQTT 3.1.1 section 3.1.2.9)
+	WillTopic      string // Published (retained) by the broker when the connection is lost, not set when empty
+	WillMessage    string
+	KeepAlive      time.Duration // Interval of keep alive pings, 0 disables them
+	DialTimeout    time.Duration
+	ReconnectDelay time.Duration // Wait time after the first failed connect, 0 dials again on every Publish
+
+	mu       sync.Mutex
+	conn     net.Conn
+	failures int       // connects that failed in a row
+	retryAt  time.Time // no connect is made before retryAt
+	lastErr  error     // error of the last failed connect
+}
+
+const maxReconnectDelay = time.Minute
+
+// NewMQTTClient returns a client for broker with a 30s keep alive and a reconnect delay of 1s
+func NewMQTTClient(broker, clientID string) *MQTTClient {
+	return &MQTTClient{
+		Broker:         broker,
+		ClientID:       clientID,
+		KeepAlive:      30 * time.Second,
+		DialTimeout:    5 * time.Second,
+		ReconnectDelay: time.Second,
+	}
+}
+
+// Publish sends payload to topic with QoS 0
+func (m *MQTTClient) Publish(topic string, payload []byte, retain bool) error {
+	flags := byte(0x00)
+	if retain {
+		flags = 0x01
+	}
+	body := append(mqttString(topic), payload...)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.connect(); err != nil {
+		return err
+	}
+	if err := m.write(0x30|flags, body); err != nil {
+		m.reset()
+		return err
+	}
+	return nil
+}
+
+// Close disconnects cleanly from the broker, the last will is not published
+func (m *MQTTClient) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil {
+		return nil
+	}
+	m.write(0xE0, nil) // DISCONNECT
+	err := m.conn.Close()
+	m.conn = nil
+	return err
+}
+
+// connect dials the broker when there is no connection, backing off after failed attempts. It must be called with mu held.
+func (m *MQTTClient) connect() error {
+	if m.conn != nil {
+		return nil
+	}
+	if m.Password != "" && m.Username == "" {
+		return ErrMQTTPasswordWithoutUsername
+	}
+	if now := time.Now(); now.Before(m.retryAt) {
+		return fmt.Errorf("not connected, retrying in %s: %w", m.retryAt.Sub(now).Round(time.Millisecond), m.lastErr)
+	}
+	if err := m.dial(); err != nil {
+		m.failures++
+		delay := m.ReconnectDelay
+		for i := 1; i < m.failures && delay < maxReconnectDelay; i++ {
+			delay *= 2
+		}
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+		m.retryAt = time.Now().Add(delay)
+		m.lastErr = err
+		return err
+	}
+	m.failures = 0
+	return nil
+}
+
+// dial connects to the broker and sends CONNECT. It must be called with mu held.
+func (m *MQTTClient) dial() error {
+	addr := strings.TrimPrefix(strings.TrimPrefix(m.Broker, "tcp://"), "mqtt://")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "1883")
+	}
+	conn, err := net.DialTimeout("tcp", addr, m.DialTimeout)
+	if err != nil {
+		return err
+	}
+	m.conn = conn
+
+	flags := byte(0x02) // clean session
+	payload := mqttString(m.ClientID)
+	if m.WillTopic != "" {
+		flags |= 0x04 | 0x20 // will, retained
+		payload = append(payload, mqttString(m.WillTopic)...)
+		payload = append(payload, mqttString(m.WillMessage)...)
+	}
+	if m.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(m.Username)...)
+	}
+	if m.Password != "" {
+		flags |= 0x40
+		payload = append(payload, mqttString(m.Password)...)
+	}
+	keepAlive := uint16(m.KeepAlive / time.Second)
+	header := append(mqttString("MQTT"), 0x04, flags, byte(keepAlive>>8), byte(keepAlive))
+	if err := m.write(0x10, append(header, payload...)); err != nil {
+		m.reset()
+		return err
+	}
+
+	// CONNACK: 0x20 0x02 <session present> <return code>
+	ack := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(m.DialTimeout))
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		m.reset()
+		return fmt.Errorf("reading CONNACK: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if ack[0] != 0x20 || ack[1] != 0x02 {
+		m.reset()
+		return fmt.Errorf("%w: expected CONNACK, got %X", ErrMQTTConnectionRefused, ack)
+	}
+	if ack[3] != 0x00 {
+		m.reset()
+		return fmt.Errorf("%w: return code %d", ErrMQTTConnectionRefused, ack[3])
+	}
+	go m.keepAlive(conn)
+	return nil
+}
+
+// keepAlive pings the broker and discards everything it sends (PINGRESP) until conn is closed
+func (m *MQTTClient) keepAlive(conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	if m.KeepAlive > 0 {
+		go func() {
+			ticker := time.NewTicker(m.KeepAlive / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+				}
+				m.mu.Lock()
+				if m.conn == conn && m.write(0xC0, nil) != nil { // PINGREQ
+					m.reset()
+				}
+				m.mu.Unlock()
+			}
+		}()
+	}
+	io.Copy(io.Discard, conn)
+	m.mu.Lock()
+	if m.conn == conn {
+		m.reset()
+	}
+	m.mu.Unlock()
+}
+
+// write sends a single control packet. It must be called with mu held.
+func (m *MQTTClient) write(packetType byte, body []byte) error {
+	packet := []byte{packetType}
+	// remaining length, 7 bits per byte with a continuation bit
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := m.conn.Write(append(packet, body...))
+	return err
+}
+
+// reset closes the current connection. It must be called with mu held.
+func (m *MQTTClient) reset() {
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+	}
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+/*
+MQTTPublisher publishes poller samples to MQTT for use with Home Assistant.
+
+Every field of NormalizedNormalInfoResponse is published (retained) to its own
+topic <TopicPrefix>/<device>/<field>. The first time a device is seen, a Home
+Assistant discovery config is published for every field to
+<DiscoveryPrefix>/sensor/<TopicPrefix>_<device>/<field>/config, with the
+device_class, unit_of_measurement and state_class derived from the unit of the field.
+
+A device is available when its last poll succeeded, which is published to
+<TopicPrefix>/<device>/availability. The connection of the publisher itself is
+tracked on <TopicPrefix>/status, using the last will of the client.
+*/
+type MQTTPublisher struct {
+	Client          *MQTTClient
+	TopicPrefix     string
+	DiscoveryPrefix string // Discovery configs are not published when empty
+
+	mu         sync.Mutex
+	discovered map[string]KnownInverter // device details the discovery configs were published with, by device ID
+}
+
+// NewMQTTPublisher returns a publisher for client, typically with topic prefix "solax" and discovery prefix "homeassistant".
+// The last will of client is set to mark the publisher offline.
+func NewMQTTPublisher(client *MQTTClient, topicPrefix, discoveryPrefix string) *MQTTPublisher {
+	p := &MQTTPublisher{Client: client, TopicPrefix: topicPrefix, DiscoveryPrefix: discoveryPrefix}
+	client.WillTopic = p.statusTopic()
+	client.WillMessage = "offline"
+	return p
+}
+
+// Publish publishes the availability of device and, when the poll succeeded, all fields of the sample
+func (p *MQTTPublisher) Publish(device KnownInverter, sample Sample) error {
+	if err := p.Client.Publish(p.statusTopic(), []byte("online"), true); err != nil {
+		return err
+	}
+	if err := p.discover(device); err != nil {
+		return err
+	}
+
+	id := mqttDeviceID(device)
+	if sample.Err != nil {
+		return p.Client.Publish(p.topic(id, "availability"), []byte("offline"), true)
+	}
+	for _, f := range NormalizeInfoResponse(*sample.Info).Fields() {
+		if err := p.Client.Publish(p.topic(id, f.Name), []byte(f.String()), true); err != nil {
+			return err
+		}
+	}
+	return p.Client.Publish(p.topic(id, "availability"), []byte("online"), true)
+}
+
+// Close marks the publisher offline and disconnects
+func (p *MQTTPublisher) Close() error {
+	p.Client.Publish(p.statusTopic(), []byte("offline"), true)
+	return p.Client.Close()
+}
+
+// HomeAssistantConfig is the discovery config of a single sensor
+type HomeAssistantConfig struct {
+	Name              string                    `json:"name"`
+	UniqueID          string                    `json:"unique_id"`
+	StateTopic        string                    `json:"state_topic"`
+	DeviceClass       string                    `json:"device_class,omitempty"`
+	UnitOfMeasurement string                    `json:"unit_of_measurement,omitempty"`
+	StateClass        string                    `json:"state_class,omitempty"`
+	Availability      []HomeAssistantTopic      `json:"availability"`
+	AvailabilityMode  string                    `json:"availability_mode"`
+	Device            HomeAssistantDeviceConfig `json:"device"`
+}
+
+type HomeAssistantTopic struct {
+	Topic string `json:"topic"`
+}
+
+type HomeAssistantDeviceConfig struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+// HomeAssistantConfigs returns the discovery config topics and configs for all fields of device
+func (p *MQTTPublisher) HomeAssistantConfigs(device KnownInverter) map[string]HomeAssistantConfig {
+	id := mqttDeviceID(device)
+	name := "Solax " + id
+	configs := map[string]HomeAssistantConfig{}
+	for _, f := range (NormalizedNormalInfoResponse{}).Fields() {
+		deviceClass, stateClass := homeAssistantClasses(f)
+		configs[fmt.Sprintf("%s/sensor/%s_%s/%s/config", p.DiscoveryPrefix, p.TopicPrefix, id, f.Name)] = HomeAssistantConfig{
+			Name:              f.Name,
+			UniqueID:          fmt.Sprintf("%s_%s_%s", p.TopicPrefix, id, f.Name),
+			StateTopic:        p.topic(id, f.Name),
+			DeviceClass:       deviceClass,
+			UnitOfMeasurement: f.Unit,
+			StateClass:        stateClass,
+			Availability:      []HomeAssistantTopic{{p.statusTopic()}, {p.topic(id, "availability")}},
+			AvailabilityMode:  "all",
+			Device: HomeAssistantDeviceConfig{
+				Identifiers:  []string{p.TopicPrefix + "_" + id},
+				Name:         name,
+				Manufacturer: "Solax",
+				Model:        device.Model,
+				SWVersion:    device.FirmwareVersion,
+			},
+		}
+	}
+	return configs
+}
+
+// discover publishes the discovery configs of device once, and again when its model or firmware changed
+func (p *MQTTPublisher) discover(device KnownInverter) error {
+	if p.DiscoveryPrefix == "" {
+		return nil
+	}
+	id := mqttDeviceID(device)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if published, ok := p.discovered[id]; ok && published.Model == device.Model && published.FirmwareVersion == device.FirmwareVersion {
+		return nil
+	}
+	for topic, config := range p.HomeAssistantConfigs(device) {
+		body, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		if err := p.Client.Publish(topic, body, true); err != nil {
+			return err
+		}
+	}
+	if p.discovered == nil {
+		p.discovered = map[string]KnownInverter{}
+	}
+	p.discovered[id] = device
+	return nil
+}
+
+func (p *MQTTPublisher) topic(id, name string) string {
+	return p.TopicPrefix + "/" + id + "/" + name
+}
+
+func (p *MQTTPublisher) statusTopic() string {
+	return p.TopicPrefix + "/status"
+}
+
+// homeAssistantClasses returns the device_class and state_class for a field, based on its unit
+func homeAssistantClasses(f Field) (deviceClass, stateClass string) {
+	switch f.Unit {
+	case "kWh":
+		return "energy", "total_increasing"
+	case "h":
+		return "duration", "total_increasing"
+	case "W":
+		return "power", "measurement"
+	case "V":
+		return "voltage", "measurement"
+	case "A":
+		return "current", "measurement"
+	case "Hz":
+		return "frequency", "measurement"
+	case "°C":
+		return "temperature", "measurement"
+	}
+	switch f.Value.(type) {
+	case string, []string:
+		return "", ""
+	}
+	return "", "measurement"
+}
+
+// mqttDeviceID identifies device in topics: its serial number, registration serial or address
+func mqttDeviceID(device KnownInverter) string {
+	if s := strings.TrimSpace(device.SerialNumber); s != "" {
+		return s
+	}
+	if len(device.Serial) > 0 {
+		return strings.ToUpper(hex.EncodeToString(device.Serial))
+	}
+	return fmt.Sprintf("address%d", device.Address)
+}
@@ -0,0 +1,288 @@
+package solaxx1rs485
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mqttMessage struct {
+	Topic   string
+	Payload string
+	Retain  bool
+}
+
+// mqttBroker is a minimal local broker that answers CONNECT and PINGREQ and records all PUBLISH packets
+type mqttBroker struct {
+	Addr string
+
+	mu       sync.Mutex
+	connects [][]byte // CONNECT bodies
+	messages []mqttMessage
+	pings    int
+	conns    []net.Conn
+}
+
+func newMQTTBroker(t *testing.T, returnCode byte) *mqttBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b := &mqttBroker{Addr: l.Addr().String()}
+	t.Cleanup(func() {
+		l.Close()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for _, c := range b.conns {
+			c.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.conns = append(b.conns, conn)
+			b.mu.Unlock()
+			go b.serve(conn, returnCode)
+		}
+	}()
+	return b
+}
+
+func (b *mqttBroker) serve(conn net.Conn, returnCode byte) {
+	defer conn.Close()
+	for {
+		packetType, body, err := readMQTTPacket(conn)
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		switch packetType >> 4 {
+		case 0x1: // CONNECT
+			b.connects = append(b.connects, body)
+			conn.Write([]byte{0x20, 0x02, 0x00, returnCode})
+		case 0x3: // PUBLISH
+			n := int(body[0])<<8 | int(body[1])
+			b.messages = append(b.messages, mqttMessage{Topic: string(body[2 : 2+n]), Payload: string(body[2+n:]), Retain: packetType&0x01 == 1})
+		case 0xC: // PINGREQ
+			b.pings++
+			conn.Write([]byte{0xD0, 0x00})
+		}
+		b.mu.Unlock()
+	}
+}
+
+func readMQTTPacket(r io.Reader) (byte, []byte, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	packetType := b[0]
+	length, multiplier := 0, 1
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		length += int(b[0]&0x7F) * multiplier
+		multiplier *= 128
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return packetType, body, err
+}
+
+// retained returns the last retained payload per topic
+func (b *mqttBroker) retained() map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	topics := map[string]string{}
+	for _, m := range b.messages {
+		if m.Retain {
+			topics[m.Topic] = m.Payload
+		}
+	}
+	return topics
+}
+
+func (b *mqttBroker) waitForMessages(t *testing.T, n int) {
+	require.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return len(b.messages) >= n
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestMQTTClient(t *testing.T) {
+	t.Run("Connects and publishes", func(t *testing.T) {
+		broker := newMQTTBroker(t, 0x00)
+		c := NewMQTTClient("tcp://"+broker.Addr, "test")
+		c.Username = "user"
+		c.Password = "secret"
+		c.WillTopic = "solax/status"
+		c.WillMessage = "offline"
+		defer c.Close()
+
+		require.NoError(t, c.Publish("a/b", []byte("1"), true))
+		require.NoError(t, c.Publish("a/c", make([]byte, 200), false)) // remaining length needs two bytes
+		broker.waitForMessages(t, 2)
+
+		require.Len(t, broker.connects, 1)
+		connect := broker.connects[0]
+		require.Equal(t, []byte{0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04}, connect[:7])
+		require.Equal(t, byte(0x80|0x40|0x20|0x04|0x02), connect[7])
+		require.Equal(t, []byte{0x00, 30}, connect[8:10])
+		require.Contains(t, string(connect), "solax/status")
+		require.Contains(t, string(connect), "secret")
+
+		require.Equal(t, mqttMessage{Topic: "a/b", Payload: "1", Retain: true}, broker.messages[0])
+		require.Len(t, broker.messages[1].Payload, 200)
+		require.False(t, broker.messages[1].Retain)
+	})
+
+	t.Run("Refused connection", func(t *testing.T) {
+		broker := newMQTTBroker(t, 0x05)
+		c := NewMQTTClient(broker.Addr, "test")
+		err := c.Publish("a/b", []byte("1"), false)
+		require.True(t, errors.Is(err, ErrMQTTConnectionRefused))
+	})
+
+	t.Run("Password without username", func(t *testing.T) {
+		broker := newMQTTBroker(t, 0x00)
+		c := NewMQTTClient(broker.Addr, "test")
+		c.Password = "secret"
+		require.ErrorIs(t, c.Publish("a/b", []byte("1"), false), ErrMQTTPasswordWithoutUsername)
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+		require.Empty(t, broker.conns)
+	})
+
+	t.Run("Reconnects after the broker closed the connection", func(t *testing.T) {
+		broker := newMQTTBroker(t, 0x00)
+		c := NewMQTTClient(broker.Addr, "test")
+		defer c.Close()
+		require.NoError(t, c.Publish("a/b", []byte("1"), false))
+		broker.waitForMessages(t, 1)
+
+		broker.mu.Lock()
+		broker.conns[0].Close()
+		broker.mu.Unlock()
+		require.Eventually(t, func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.conn == nil
+		}, time.Second, 5*time.Millisecond)
+
+		require.NoError(t, c.Publish("a/b", []byte("2"), false))
+		broker.waitForMessages(t, 2)
+		require.Len(t, broker.connects, 2)
+	})
+
+	t.Run("Backs off after a failed connect", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		l.Close()
+
+		c := NewMQTTClient(addr, "test")
+		c.ReconnectDelay = 50 * time.Millisecond
+		require.Error(t, c.Publish("a/b", []byte("1"), false))
+		err = c.Publish("a/b", []byte("2"), false)
+		require.ErrorContains(t, err, "retrying in")
+		require.Equal(t, 1, c.failures)
+
+		time.Sleep(60 * time.Millisecond)
+		require.Error(t, c.Publish("a/b", []byte("3"), false))
+		require.Equal(t, 2, c.failures)
+		require.Greater(t, time.Until(c.retryAt), 60*time.Millisecond) // doubled
+	})
+
+	t.Run("Keep alive", func(t *testing.T) {
+		broker := newMQTTBroker(t, 0x00)
+		c := NewMQTTClient(broker.Addr, "test")
+		c.KeepAlive = 20 * time.Millisecond
+		defer c.Close()
+		require.NoError(t, c.Publish("a/b", []byte("1"), false))
+		require.Eventually(t, func() bool {
+			broker.mu.Lock()
+			defer broker.mu.Unlock()
+			return broker.pings >= 2
+		}, time.Second, 5*time.Millisecond)
+	})
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	broker := newMQTTBroker(t, 0x00)
+	p := NewMQTTPublisher(NewMQTTClient(broker.Addr, "test"), "solax", "homeassistant")
+	defer p.Close()
+	device := KnownInverter{Address: 10, SerialNumber: "XB123", Model: "X1-Air", FirmwareVersion: "1.00"}
+	fields := len((NormalizedNormalInfoResponse{}).Fields())
+
+	require.NoError(t, p.Publish(device, Sample{Inverter: *device.Inverter(), Info: &NormalInfoResponse{Power: 1500, EnergyTotal: 123, Mode: 2, ErrMessage: 1 << 31}}))
+	// status + discovery configs + fields + availability
+	broker.waitForMessages(t, 1+fields+fields+1)
+	topics := broker.retained()
+	require.Equal(t, "online", topics["solax/status"])
+	require.Equal(t, "online", topics["solax/XB123/availability"])
+	require.Equal(t, "1500", topics["solax/XB123/Power"])
+	require.Equal(t, "12.3", topics["solax/XB123/EnergyTotal"])
+	require.Equal(t, "Normal", topics["solax/XB123/Mode"])
+	require.Equal(t, "TzProtectFault", topics["solax/XB123/ErrMessage"])
+
+	var config HomeAssistantConfig
+	require.NoError(t, json.Unmarshal([]byte(topics["homeassistant/sensor/solax_XB123/EnergyTotal/config"]), &config))
+	require.Equal(t, "energy", config.DeviceClass)
+	require.Equal(t, "kWh", config.UnitOfMeasurement)
+	require.Equal(t, "total_increasing", config.StateClass)
+	require.Equal(t, "solax/XB123/EnergyTotal", config.StateTopic)
+	require.Equal(t, []HomeAssistantTopic{{"solax/status"}, {"solax/XB123/availability"}}, config.Availability)
+	require.Equal(t, "X1-Air", config.Device.Model)
+
+	require.NoError(t, json.Unmarshal([]byte(topics["homeassistant/sensor/solax_XB123/Power/config"]), &config))
+	require.Equal(t, "power", config.DeviceClass)
+	require.Equal(t, "measurement", config.StateClass)
+
+	config = HomeAssistantConfig{}
+	require.NoError(t, json.Unmarshal([]byte(topics["homeassistant/sensor/solax_XB123/Mode/config"]), &config))
+	require.Empty(t, config.DeviceClass)
+	require.Empty(t, config.UnitOfMeasurement)
+	require.Empty(t, config.StateClass)
+
+	// A failed poll marks the device unavailable, discovery configs are only published once
+	require.NoError(t, p.Publish(device, Sample{Inverter: *device.Inverter(), Err: ErrNoInverter}))
+	broker.waitForMessages(t, 1+fields+fields+1+2)
+	require.Equal(t, "offline", broker.retained()["solax/XB123/availability"])
+	broker.mu.Lock()
+	require.Len(t, broker.messages, 1+fields+fields+1+2)
+	broker.mu.Unlock()
+
+	t.Run("Inverter that is off at startup", func(t *testing.T) {
+		broker := newMQTTBroker(t, 0x00)
+		p := NewMQTTPublisher(NewMQTTClient(broker.Addr, "test"), "solax", "homeassistant")
+		defer p.Close()
+		device := KnownInverter{Address: 3}
+		require.NoError(t, p.Publish(device, Sample{Inverter: *device.Inverter(), Err: ErrNoInverter}))
+		broker.waitForMessages(t, 1+fields+1)
+		require.Equal(t, "offline", broker.retained()["solax/address3/availability"])
+
+		// the discovery configs are published again with the details once the inverter answers
+		device.Model = "X1-Air"
+		require.NoError(t, p.Publish(device, Sample{Inverter: *device.Inverter(), Info: &NormalInfoResponse{Power: 10}}))
+		broker.waitForMessages(t, 1+fields+1+1+fields+fields+1)
+		topics := broker.retained()
+		require.Equal(t, "online", topics["solax/address3/availability"])
+		var config HomeAssistantConfig
+		require.NoError(t, json.Unmarshal([]byte(topics["homeassistant/sensor/solax_address3/Power/config"]), &config))
+		require.Equal(t, "X1-Air", config.Device.Model)
+	})
+}
@@ -0,0 +1,250 @@
+package solaxx1rs485
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidNotifier = errors.New("Invalid notifier")
+
+// Notifier sends alerts, e.g. to a webhook or by mail
+type Notifier interface {
+	Notify(context.Context, Alert) error
+}
+
+/*
+NotifierConfig configures a notifier, the fields used depend on Type:
+
+	webhook	POST the alert as JSON to URL, with Headers
+	smtp	Mail the alert from From to To through the server at Addr (host:port),
+		authenticating with Username and Password when set
+	exec	Run Command with the alert as JSON on stdin and in SOLAX_ALERT_* environment variables
+	stdout	Print the alert with a timestamp
+*/
+type NotifierConfig struct {
+	Name     string // Name used in Rule.Notify, defaults to the type and its index
+	Type     string
+	URL      string            `json:",omitempty"`
+	Headers  map[string]string `json:",omitempty"`
+	Addr     string            `json:",omitempty"`
+	From     string            `json:",omitempty"`
+	To       []string          `json:",omitempty"`
+	Username string            `json:",omitempty"`
+	Password string            `json:",omitempty"`
+	Command  []string          `json:",omitempty"`
+}
+
+// NewNotifier returns the notifier for config
+func NewNotifier(config NotifierConfig) (Notifier, error) {
+	switch strings.ToLower(config.Type) {
+	case "webhook":
+		if config.URL == "" {
+			return nil, fmt.Errorf("%w %q: webhook needs a URL", ErrInvalidNotifier, config.Name)
+		}
+		n := NewWebhookNotifier(config.URL)
+		n.Headers = config.Headers
+		return n, nil
+	case "smtp":
+		if config.Addr == "" || config.From == "" || len(config.To) == 0 {
+			return nil, fmt.Errorf("%w %q: smtp needs Addr, From and To", ErrInvalidNotifier, config.Name)
+		}
+		return &SMTPNotifier{Addr: config.Addr, From: config.From, To: config.To, Username: config.Username, Password: config.Password}, nil
+	case "exec":
+		if len(config.Command) == 0 {
+			return nil, fmt.Errorf("%w %q: exec needs a Command", ErrInvalidNotifier, config.Name)
+		}
+		return &CommandNotifier{Command: config.Command}, nil
+	case "stdout":
+		return NewWriterNotifier(os.Stdout), nil
+	}
+	return nil, fmt.Errorf("%w %q: unknown type %q", ErrInvalidNotifier, config.Name, config.Type)
+}
+
+// WebhookNotifier posts alerts as JSON to URL
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string // Extra headers, e.g. Authorization
+	Client  *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("posting to %s: %s: %s", n.URL, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// SMTPNotifier mails alerts, using STARTTLS when the server supports it
+type SMTPNotifier struct {
+	Addr     string // host:port
+	From     string
+	To       []string
+	Username string // PLAIN authentication is used when set
+	Password string
+	Timeout  time.Duration // Bounds the whole session when ctx has no earlier deadline, defaults to 30s
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, a Alert) error {
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return err
+	}
+	subject := "[solax] " + a.Message
+	if a.Resolved {
+		subject = "[solax] [resolved] " + a.Message
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n", n.From, strings.Join(n.To, ", "), subject, a.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "%s\r\n\r\nRule: %s\r\nInverter: %d\r\nTime: %s\r\n", a.Message, a.Rule, a.Address, a.Time.Format(time.RFC3339))
+
+	timeout := n.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", n.Addr)
+	if err != nil {
+		return err
+	}
+	// net/smtp doesn't take a context, closing the connection ends the session on cancellation or timeout
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	err = n.send(conn, host, msg.Bytes())
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// send runs the SMTP session on conn, like smtp.SendMail
+func (n *SMTPNotifier) send(conn net.Conn, host string, msg []byte) error {
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.Username, n.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	for _, to := range n.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+/*
+CommandNotifier runs a command for every alert.
+
+The alert is written as JSON to stdin, and is available in the environment as
+SOLAX_ALERT_RULE, SOLAX_ALERT_ADDRESS, SOLAX_ALERT_TIME (RFC 3339),
+SOLAX_ALERT_MESSAGE and SOLAX_ALERT_RESOLVED ("true" or "false").
+*/
+type CommandNotifier struct {
+	Command []string // Program and arguments, not interpreted by a shell
+}
+
+func (n *CommandNotifier) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, n.Command[0], n.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"SOLAX_ALERT_RULE="+a.Rule,
+		"SOLAX_ALERT_ADDRESS="+strconv.Itoa(int(a.Address)),
+		"SOLAX_ALERT_TIME="+a.Time.Format(time.RFC3339),
+		"SOLAX_ALERT_MESSAGE="+a.Message,
+		"SOLAX_ALERT_RESOLVED="+strconv.FormatBool(a.Resolved),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("running %s: %w: %s", n.Command[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// WriterNotifier prints alerts as lines with a timestamp, e.g. to stdout
+type WriterNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterNotifier(w io.Writer) *WriterNotifier {
+	return &WriterNotifier{w: w}
+}
+
+func (n *WriterNotifier) Notify(ctx context.Context, a Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, err := fmt.Fprintf(n.w, "%s %s\n", a.Time.Local().Format("2006-01-02 15:04:05"), a)
+	return err
+}
@@ -0,0 +1,157 @@
+package solaxx1rs485
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testAlert() Alert {
+	return Alert{Rule: "Hot", Address: 3, Time: time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC), Message: "Hot: inverter 3, Temperature is 75"}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got Alert
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "no such hook", http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		auth = r.Header.Get("Authorization")
+		if err := json.Unmarshal(body, &got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	n, err := NewNotifier(NotifierConfig{Type: "webhook", URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}})
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), testAlert()))
+	require.Equal(t, testAlert(), got)
+	require.Equal(t, "Bearer token", auth)
+
+	n.(*WebhookNotifier).URL = server.URL + "/fail"
+	err = n.Notify(context.Background(), testAlert())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no such hook")
+}
+
+func TestCommandNotifier(t *testing.T) {
+	dir := t.TempDir()
+	n, err := NewNotifier(NotifierConfig{Type: "exec", Command: []string{"sh", "-c", `cat > "$0/alert.json" && echo "$SOLAX_ALERT_ADDRESS $SOLAX_ALERT_RESOLVED" > "$0/env"`, dir}})
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), testAlert()))
+
+	body, err := os.ReadFile(filepath.Join(dir, "alert.json"))
+	require.NoError(t, err)
+	var got Alert
+	require.NoError(t, json.Unmarshal(body, &got))
+	require.Equal(t, testAlert(), got)
+	env, err := os.ReadFile(filepath.Join(dir, "env"))
+	require.NoError(t, err)
+	require.Equal(t, "3 false\n", string(env))
+
+	n = &CommandNotifier{Command: []string{"sh", "-c", "echo broken >&2; exit 1"}}
+	err = n.Notify(context.Background(), testAlert())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "broken")
+}
+
+// smtpServer accepts a single session and returns the message, it stops responding after hang commands when hang > 0
+func smtpServer(t *testing.T, hang int) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 localhost\r\n")
+		for n := 1; ; n++ {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if n == hang {
+				io.Copy(io.Discard, r)
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				fmt.Fprint(conn, "250 ok\r\n")
+			case "DATA":
+				fmt.Fprint(conn, "354 go ahead\r\n")
+				var msg strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					msg.WriteString(line)
+				}
+				messages <- msg.String()
+				fmt.Fprint(conn, "250 ok\r\n")
+			case "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "502 not implemented\r\n")
+			}
+		}
+	}()
+	return l.Addr().String(), messages
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	t.Run("Mail", func(t *testing.T) {
+		addr, messages := smtpServer(t, 0)
+		n := &SMTPNotifier{Addr: addr, From: "solax@example.com", To: []string{"me@example.com"}}
+		require.NoError(t, n.Notify(context.Background(), testAlert()))
+		msg := <-messages
+		require.Contains(t, msg, "Subject: [solax] Hot: inverter 3, Temperature is 75\r\n")
+		require.Contains(t, msg, "Rule: Hot\r\n")
+	})
+
+	t.Run("Cancelled while the server doesn't respond", func(t *testing.T) {
+		addr, _ := smtpServer(t, 2)
+		n := &SMTPNotifier{Addr: addr, From: "solax@example.com", To: []string{"me@example.com"}}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		require.ErrorIs(t, n.Notify(ctx, testAlert()), context.DeadlineExceeded)
+		require.Less(t, time.Since(start), time.Second)
+	})
+}
+
+func TestNewNotifier(t *testing.T) {
+	for _, c := range []NotifierConfig{
+		{Type: "webhook"},
+		{Type: "smtp", Addr: "localhost:25"},
+		{Type: "exec"},
+		{Type: "pager"},
+	} {
+		_, err := NewNotifier(c)
+		require.ErrorIs(t, err, ErrInvalidNotifier, c.Type)
+	}
+	n, err := NewNotifier(NotifierConfig{Type: "SMTP", Addr: "localhost:25", From: "solax@example.com", To: []string{"me@example.com"}})
+	require.NoError(t, err)
+	require.IsType(t, &SMTPNotifier{}, n)
+}
@@ -0,0 +1,92 @@
+package solaxx1rs485
+
+import (
+	"context"
+	"time"
+)
+
+// Sample is the result of a single GetInfo call made by the Poller
+type Sample struct {
+	Time     time.Time
+	Inverter Inverter
+	Info     *NormalInfoResponse // nil when Err is set
+	Raw      []byte              // Raw response frame of the successful attempt
+	Err      error
+}
+
+/*
+Poller periodically calls GetInfo for a set of inverters.
+
+All inverters are polled one after the other at the start of every interval.
+Samples are timestamped and delivered to a callback (Run) or on a channel
+(Samples), failed polls are delivered as well with Err set.
+*/
+type Poller struct {
+	Client    *Client
+	Inverters []Inverter
+	Interval  time.Duration
+	Timeout   time.Duration // Bounds a single GetInfo call, 0 means only the Client WaitTime applies
+}
+
+func NewPoller(client *Client, interval time.Duration, inverters ...Inverter) *Poller {
+	return &Poller{Client: client, Inverters: inverters, Interval: interval}
+}
+
+// Run polls until ctx is done and calls handle for every sample. It always returns ctx.Err().
+func (p *Poller) Run(ctx context.Context, handle func(Sample)) error {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		for _, inv := range p.Inverters {
+			sample := p.poll(ctx, inv)
+			if done(ctx) {
+				// the sample was cut short by the shutdown, don't report it as a failure
+				return ctx.Err()
+			}
+			handle(sample)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Samples polls in the background until ctx is done and delivers the samples on the returned channel.
+// The channel is closed when polling stops.
+func (p *Poller) Samples(ctx context.Context) <-chan Sample {
+	samples := make(chan Sample, len(p.Inverters))
+	go func() {
+		defer close(samples)
+		p.Run(ctx, func(s Sample) {
+			select {
+			case samples <- s:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return samples
+}
+
+// done reports whether ctx is done or its deadline passed, the deadline may be noticed
+// by the Client just before the context itself is done
+func done(ctx context.Context) bool {
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		<-ctx.Done()
+	}
+	return ctx.Err() != nil
+}
+
+func (p *Poller) poll(ctx context.Context, inv Inverter) Sample {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	info, raw, err := p.Client.getInfo(ctx, inv.Address)
+	if err != nil {
+		return Sample{Time: time.Now(), Inverter: inv, Err: err}
+	}
+	return Sample{Time: time.Now(), Inverter: inv, Info: &info, Raw: raw}
+}
@@ -0,0 +1,52 @@
+package solaxx1rs485
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoller(t *testing.T) {
+	c, _ := NewClientWithConnection(&busConnection{})
+	p := NewPoller(c, 20*time.Millisecond, Inverter{Address: 1}, Inverter{Address: 2})
+
+	t.Run("Run", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		samples := []Sample{}
+		err := p.Run(ctx, func(s Sample) { samples = append(samples, s) })
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.GreaterOrEqual(t, len(samples), 4)
+		for i, s := range samples {
+			require.NoError(t, s.Err)
+			require.Equal(t, byte(i%2+1), s.Inverter.Address)
+			require.Equal(t, uint16(s.Inverter.Address), s.Info.Power)
+			require.False(t, s.Time.IsZero())
+			require.Len(t, s.Raw, 61)
+		}
+	})
+
+	t.Run("Samples", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		samples := p.Samples(ctx)
+		first := <-samples
+		require.NoError(t, first.Err)
+		require.Equal(t, byte(1), first.Inverter.Address)
+		cancel()
+		for range samples {
+		}
+	})
+
+	t.Run("Failed polls are delivered", func(t *testing.T) {
+		c, _ := NewClientWithConnection(&mockConnection{})
+		c.WaitTime = 5 * time.Millisecond
+		p := NewPoller(c, time.Second, Inverter{Address: 1})
+		ctx, cancel := context.WithCancel(context.Background())
+		s := <-p.Samples(ctx)
+		cancel()
+		require.ErrorIs(t, s.Err, ErrEmptyBody)
+		require.Nil(t, s.Info)
+	})
+}
@@ -0,0 +1,117 @@
+package solaxx1rs485
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+PrometheusMetrics collects samples in the Prometheus text exposition format,
+grouped per metric name, e.g. to serve them on /metrics.
+
+Label sets are passed as formatted strings, see PrometheusLabels. To keep the
+series of an inverter continuous, AddInfo only labels it with its address, the
+device details are in a separate solax_device_info metric (see AddDevice).
+*/
+type PrometheusMetrics struct {
+	names    []string
+	families map[string]*prometheusFamily
+}
+
+type prometheusFamily struct {
+	kind    string
+	help    string
+	samples []string
+}
+
+// Add adds a sample of the metric name, kind is the metric type, e.g. "gauge" or "counter"
+func (m *PrometheusMetrics) Add(name, kind, help, labels string, value float64) {
+	if m.families == nil {
+		m.families = map[string]*prometheusFamily{}
+	}
+	f, ok := m.families[name]
+	if !ok {
+		f = &prometheusFamily{kind: kind, help: help}
+		m.families[name] = f
+		m.names = append(m.names, name)
+	}
+	sample := name
+	if labels != "" {
+		sample += "{" + labels + "}"
+	}
+	f.samples = append(f.samples, sample+" "+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// AddDevice adds solax_device_info with the serial, model and firmware of the inverter
+func (m *PrometheusMetrics) AddDevice(labels string, info InverterInfoResponse) {
+	labels += "," + PrometheusLabels("serial", info.SerialNumber, "model", info.ModuleName, "firmware", info.FirmwareVersion)
+	m.Add("solax_device_info", "gauge", "Inverter details, always 1", labels, 1)
+}
+
+// AddInfo adds the metrics of a normal info response
+func (m *PrometheusMetrics) AddInfo(labels string, info NormalInfoResponse) {
+	n := NormalizeInfoResponse(info)
+	m.Add("solax_temperature_celsius", "gauge", "Inverter temperature", labels, float64(n.Temperature))
+	m.Add("solax_energy_today_kwh", "gauge", "Energy produced today, reset by the inverter at midnight", labels, n.EnergyToday)
+	m.Add("solax_pv_voltage_volts", "gauge", "PV string voltage", labels+`,pv="1"`, n.Vpv1)
+	m.Add("solax_pv_voltage_volts", "gauge", "PV string voltage", labels+`,pv="2"`, n.Vpv2)
+	m.Add("solax_pv_current_amperes", "gauge", "PV string current", labels+`,pv="1"`, n.Apv1)
+	m.Add("solax_pv_current_amperes", "gauge", "PV string current", labels+`,pv="2"`, n.Apv2)
+	m.Add("solax_ac_current_amperes", "gauge", "Grid current", labels, n.Iac)
+	m.Add("solax_ac_voltage_volts", "gauge", "Grid voltage", labels, n.Vac)
+	m.Add("solax_ac_frequency_hertz", "gauge", "Grid frequency", labels, n.Frequency)
+	m.Add("solax_power_watts", "gauge", "Output power", labels, float64(n.Power))
+	m.Add("solax_energy_kwh_total", "counter", "Total energy produced", labels, n.EnergyTotal)
+	m.Add("solax_operation_hours_total", "counter", "Total hours of operation", labels, float64(n.TimeTotal))
+	m.Add("solax_mode", "gauge", "Inverter mode (0: Wait, 1: Check, 2: Normal, 3: Fault, 4: Permanent Fault, 5: Update, 6: Selftest)", labels, float64(info.Mode))
+	m.Add("solax_grid_voltage_fault_volts", "gauge", "Grid voltage fault value", labels, n.GridVoltFault)
+	m.Add("solax_grid_frequency_fault_hertz", "gauge", "Grid frequency fault value", labels, n.GridFreqFault)
+	m.Add("solax_dci_fault_amperes", "gauge", "DC injection fault value", labels, n.DCIFault)
+	m.Add("solax_temperature_fault", "gauge", "Temperature fault value", labels, n.TemperatureFault)
+	m.Add("solax_pv_voltage_fault_volts", "gauge", "PV voltage fault value", labels+`,pv="1"`, n.PV1Fault)
+	m.Add("solax_pv_voltage_fault_volts", "gauge", "PV voltage fault value", labels+`,pv="2"`, n.PV2Fault)
+	m.Add("solax_gfc_fault_amperes", "gauge", "GFC fault value", labels, n.GFCFault)
+	for _, fault := range AllFaults() {
+		active := 0.0
+		if info.Faults().Has(fault) {
+			active = 1
+		}
+		faultLabels := PrometheusLabels("bit", strconv.Itoa(fault.Bit()), "fault", fault.String(), "category", fault.Category().String(), "severity", fault.Severity().String())
+		m.Add("solax_fault", "gauge", "Whether a fault bit of the error message is set", labels+","+faultLabels, active)
+	}
+}
+
+// WriteTo writes all samples, sorted by metric name
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	names := append([]string{}, m.names...)
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		f := m.families[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.kind)
+		for _, s := range f.samples {
+			b.WriteString(s + "\n")
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// PrometheusLabels formats name/value pairs as labels, e.g. PrometheusLabels("address", "1") returns address="1".
+// Values are trimmed and escaped, NUL bytes (e.g. padding in InverterInfoResponse) are removed.
+func PrometheusLabels(pairs ...string) string {
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, pairs[i]+`="`+prometheusEscape(pairs[i+1])+`"`)
+	}
+	return strings.Join(labels, ",")
+}
+
+var prometheusEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func prometheusEscape(s string) string {
+	return prometheusEscaper.Replace(strings.TrimSpace(strings.ReplaceAll(s, "\x00", "")))
+}
@@ -0,0 +1,64 @@
+package solaxx1rs485
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata")
+
+// golden compares got with the file testdata/name, or writes it with -update
+func golden(t *testing.T, name, got string) {
+	path := filepath.Join("testdata", name)
+	if *update {
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(got), 0o644))
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, string(want), got)
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	t.Run("Inverter", func(t *testing.T) {
+		info := NormalInfoResponse{Temperature: 42, EnergyToday: 123, Vpv1: 2105, Apv1: 35, Iac: 31, Vac: 2301, Frequency: 5001, Power: 712, EnergyTotal: 45678, TimeTotal: 1234, Mode: 2, ErrMessage: 1 << 1}
+		device := InverterInfoResponse{SerialNumber: "XB3002I1234567", ModuleName: "X1-3.0-S-D\x00\x00\x00\x00", FirmwareVersion: "1.20 "}
+
+		m := &PrometheusMetrics{}
+		labels := PrometheusLabels("address", "1")
+		m.AddDevice(labels, device)
+		m.Add("solax_up", "gauge", "Whether the last scrape of the inverter succeeded", labels, 1)
+		m.AddInfo(labels, info)
+		m.Add("solax_requests_total", "counter", "Requests sent to the inverters, excluding retries", "", 2)
+		var b strings.Builder
+		_, err := m.WriteTo(&b)
+		require.NoError(t, err)
+		golden(t, "metrics.prom", b.String())
+	})
+
+	t.Run("Failed scrape keeps the labels", func(t *testing.T) {
+		m := &PrometheusMetrics{}
+		labels := PrometheusLabels("address", "1")
+		m.Add("solax_up", "gauge", "Whether the last scrape of the inverter succeeded", labels, 0)
+		m.Add("solax_scrape_errors_total", "counter", "Failed scrapes of the inverter", labels, 3)
+		var b strings.Builder
+		_, err := m.WriteTo(&b)
+		require.NoError(t, err)
+		require.Equal(t, `# HELP solax_scrape_errors_total Failed scrapes of the inverter
+# TYPE solax_scrape_errors_total counter
+solax_scrape_errors_total{address="1"} 3
+# HELP solax_up Whether the last scrape of the inverter succeeded
+# TYPE solax_up gauge
+solax_up{address="1"} 0
+`, b.String())
+	})
+
+	t.Run("Label escaping", func(t *testing.T) {
+		require.Equal(t, `serial="XB3002I1234567",model="a\\b \"c\"\nd"`, PrometheusLabels("serial", " XB3002I1234567\x00\x00", "model", "a\\b \"c\"\nd\x00"))
+	})
+}
@@ -1,8 +1,14 @@
 package solaxx1rs485
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -21,6 +27,9 @@ var (
 	ErrInvalidBody            = errors.New("Could not parse body into valid packet")
 	ErrUnexpectedControlCode  = errors.New("Unexpected Control Code")
 	ErrUnexpectedFunctionCode = errors.New("Unexpected Function Code")
+	ErrNOACK                  = errors.New("Inverter responded with NOACK")
+	ErrUnknownConfigParameter = errors.New("Unknown config parameter")
+	ErrInvalidConfigValue     = errors.New("Invalid config value")
 )
 
 /*
@@ -127,8 +136,15 @@ Function codes:
 0x81	Inverter -> Client	CONFIRM ADDRESS
 0x02	Client -> Inverter	REMOVE ADDRESS
 0x82	Inverter -> Client	CONFIRM REMOVAL
-0x03	Client -> Inverter	RECONNECT REMOVED (?)
-0x04	Client -> Inverter  REREGISTER (?)
+0x03	Client -> Inverter	RECONNECT REMOVED
+0x83	Inverter -> Client	CONFIRM RECONNECT
+0x04	Client -> Inverter  REREGISTER
+0x84	Inverter -> Client	CONFIRM REREGISTER
+
+0x03 asks a previously removed inverter to rejoin the bus at its old address,
+0x04 asks an inverter that lost its registration (e.g. after a power cycle)
+to register again at the given address. Both use the same data layout as
+0x01/0x02 (serial followed by the address) and respond with ACK / NOACK.
 */
 
 // 0x00
@@ -238,6 +254,34 @@ func ParseUnregisterInverterResponse(body []byte) error {
 	return nil
 }
 
+// 0x03
+func ReconnectRemovedInverterRequest(serial []byte, address byte) *Packet {
+	p := DefaultPacket()
+	p.ControlCode = ControlCodeRegister
+	p.FunctionCode = 0x03
+	p.Data = append(append([]byte{}, serial...), address)
+	return p
+}
+
+// 0x83
+func ParseReconnectRemovedInverterResponse(body []byte) error {
+	return parseAckResponse(body, ControlCodeRegister, 0x83)
+}
+
+// 0x04
+func ReregisterInverterRequest(serial []byte, address byte) *Packet {
+	p := DefaultPacket()
+	p.ControlCode = ControlCodeRegister
+	p.FunctionCode = 0x04
+	p.Data = append(append([]byte{}, serial...), address)
+	return p
+}
+
+// 0x84
+func ParseReregisterInverterResponse(body []byte) error {
+	return parseAckResponse(body, ControlCodeRegister, 0x84)
+}
+
 /*
 -------------------------------------------------------------------------------
 ----- Calls related to inverter information
@@ -306,29 +350,63 @@ type NormalInfoResponse struct {
 	ErrMessage       uint32 // Error message code
 }
 
+// NormalizedNormalInfoResponse holds the info response in regular units, the unit of each field is in its `unit` tag
 type NormalizedNormalInfoResponse struct {
-	Temperature      uint16   // Celsius
-	EnergyToday      float64  // 0.1kWh -> kWh
-	Vpv1             float64  // 0.1V -> V
-	Vpv2             float64  // 0.1V -> V
-	Apv1             float64  // 0.1A -> A
-	Apv2             float64  // 0.1A -> A
-	Iac              float64  // 0.1A -> A
-	Vac              float64  // 0.1V -> V
-	Frequency        float64  // 0.01Hz -> Hz
-	Power            uint16   // 1W
-	_                uint16   // Unused
-	EnergyTotal      float64  // 0.1kWh
-	TimeTotal        uint32   // hours
-	Mode             string   // Inverter mode (0: Wait, 1: Check, 2: Normal, 3: Fault, 4: Permanent Fault, 5: Update, 6: Selftest)
-	GridVoltFault    float64  // 0.1V Grid voltage fault value -> V
-	GridFreqFault    float64  // 0.01Hz Grid frequency fault value -> Hz
-	DCIFault         float64  // mA, DJ injection fault value -> A
-	TemperatureFault float64  // Temperature fault value
-	PV1Fault         float64  // 0.1V PV1 voltage fault value -> V
-	PV2Fault         float64  // 0.1V PV2 voltage fault value -> V
-	GFCFault         float64  // mA, GFC fault value -> A
-	ErrMessage       []string // Error message code
+	Temperature      uint16    `unit:"°C"`  // Celsius
+	EnergyToday      float64   `unit:"kWh"` // 0.1kWh -> kWh
+	Vpv1             float64   `unit:"V"`   // 0.1V -> V
+	Vpv2             float64   `unit:"V"`   // 0.1V -> V
+	Apv1             float64   `unit:"A"`   // 0.1A -> A
+	Apv2             float64   `unit:"A"`   // 0.1A -> A
+	Iac              float64   `unit:"A"`   // 0.1A -> A
+	Vac              float64   `unit:"V"`   // 0.1V -> V
+	Frequency        float64   `unit:"Hz"`  // 0.01Hz -> Hz
+	Power            uint16    `unit:"W"`   // 1W
+	_                uint16    // Unused
+	EnergyTotal      float64   `unit:"kWh"` // 0.1kWh
+	TimeTotal        uint32    `unit:"h"`   // hours
+	Mode             string    // Inverter mode (0: Wait, 1: Check, 2: Normal, 3: Fault, 4: Permanent Fault, 5: Update, 6: Selftest)
+	GridVoltFault    float64   `unit:"V"`  // 0.1V Grid voltage fault value -> V
+	GridFreqFault    float64   `unit:"Hz"` // 0.01Hz Grid frequency fault value -> Hz
+	DCIFault         float64   `unit:"A"`  // mA, DJ injection fault value -> A
+	TemperatureFault float64   // Temperature fault value
+	PV1Fault         float64   `unit:"V"` // 0.1V PV1 voltage fault value -> V
+	PV2Fault         float64   `unit:"V"` // 0.1V PV2 voltage fault value -> V
+	GFCFault         float64   `unit:"A"` // mA, GFC fault value -> A
+	Faults           FaultCode // Error message code
+	ErrMessage       []string  // Names of the faults in the error message code
+}
+
+// Field is a single value of a NormalizedNormalInfoResponse
+type Field struct {
+	Name  string
+	Unit  string      // Empty for text and unitless values
+	Value interface{} // uint16, uint32, float64, string or []string
+}
+
+// String formats the value, text lists (ErrMessage) are joined with ", "
+func (f Field) String() string {
+	switch v := f.Value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []string:
+		return strings.Join(v, ", ")
+	}
+	return fmt.Sprint(f.Value)
+}
+
+// Fields returns all fields in declaration order, e.g. for outputs that publish or store every field
+func (n NormalizedNormalInfoResponse) Fields() []Field {
+	fields := []Field{}
+	v := reflect.ValueOf(n)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Name == "_" || f.Name == "Faults" {
+			continue // Faults is listed by name in ErrMessage
+		}
+		fields = append(fields, Field{Name: f.Name, Unit: f.Tag.Get("unit"), Value: v.Field(i).Interface()})
+	}
+	return fields
 }
 
 /*
@@ -369,10 +447,21 @@ Uint16 BIT28:1;//28
 Uint16 DCI_DeviceFault:1;//29
 Uint16 OtherDeviceFault:1;//30
 Uint16 BIT31:1;//31
+
+These bits are available as FaultCode constants, see NormalInfoResponse.Faults.
 */
 
+var modes = map[uint16]string{0: "Wait", 1: "Check", 2: "Normal", 3: "Fault", 4: "Permanent Fault", 5: "Update", 6: "Selftest"}
+
+// ModeName returns the name of an inverter mode as reported by GetInfo, e.g. "Normal"
+func ModeName(mode uint16) string {
+	if name, ok := modes[mode]; ok {
+		return name
+	}
+	return fmt.Sprintf("Mode %d", mode)
+}
+
 func NormalizeInfoResponse(in NormalInfoResponse) NormalizedNormalInfoResponse {
-	modes := map[uint16]string{0: "Wait", 1: "Check", 2: "Normal", 3: "Fault", 4: "Permanent Fault", 5: "Update", 6: "Selftest"}
 	res := NormalizedNormalInfoResponse{
 		Temperature:      in.Temperature,
 		EnergyToday:      float64(in.EnergyToday) / 10,
@@ -394,106 +483,10 @@ func NormalizeInfoResponse(in NormalInfoResponse) NormalizedNormalInfoResponse {
 		PV1Fault:         float64(in.PV1Fault) / 10,
 		PV2Fault:         float64(in.PV2Fault) / 10,
 		GFCFault:         float64(in.GFCFault) / 1000,
-		ErrMessage:       []string{},
+		Faults:           in.Faults(),
+		ErrMessage:       in.Faults().Names(),
 	}
 
-	// parse error messages
-	if in.ErrMessage&uint32(2147483648) == uint32(2147483648) {
-		res.ErrMessage = append(res.ErrMessage, "TzProtectFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>1) == uint32(2147483648)>>1 {
-		res.ErrMessage = append(res.ErrMessage, "MainsLostFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>2) == uint32(2147483648)>>2 {
-		res.ErrMessage = append(res.ErrMessage, "GridVoltFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>3) == uint32(2147483648)>>3 {
-		res.ErrMessage = append(res.ErrMessage, "GridFreqFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>4) == uint32(2147483648)>>4 {
-		res.ErrMessage = append(res.ErrMessage, "PLLLostFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>5) == uint32(2147483648)>>5 {
-		res.ErrMessage = append(res.ErrMessage, "BusVoltFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>6) == uint32(2147483648)>>6 {
-		res.ErrMessage = append(res.ErrMessage, "BIT06")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>7) == uint32(2147483648)>>7 {
-		res.ErrMessage = append(res.ErrMessage, "OciFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>8) == uint32(2147483648)>>8 {
-		res.ErrMessage = append(res.ErrMessage, "Dci_OCP_Fault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>9) == uint32(2147483648)>>9 {
-		res.ErrMessage = append(res.ErrMessage, "ResidualCurrentFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>10) == uint32(2147483648)>>10 {
-		res.ErrMessage = append(res.ErrMessage, "PvVoltFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>11) == uint32(2147483648)>>11 {
-		res.ErrMessage = append(res.ErrMessage, "Ac10Mins_Voltage_Fault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>12) == uint32(2147483648)>>12 {
-		res.ErrMessage = append(res.ErrMessage, "IsolationFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>13) == uint32(2147483648)>>13 {
-		res.ErrMessage = append(res.ErrMessage, "TemperatureOverFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>14) == uint32(2147483648)>>14 {
-		res.ErrMessage = append(res.ErrMessage, "FanFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>15) == uint32(2147483648)>>15 {
-		res.ErrMessage = append(res.ErrMessage, "bit15")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>16) == uint32(2147483648)>>16 {
-		res.ErrMessage = append(res.ErrMessage, "SpiCommsFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>17) == uint32(2147483648)>>17 {
-		res.ErrMessage = append(res.ErrMessage, "SciCommsFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>18) == uint32(2147483648)>>18 {
-		res.ErrMessage = append(res.ErrMessage, "BIT18")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>19) == uint32(2147483648)>>19 {
-		res.ErrMessage = append(res.ErrMessage, "InputConfigFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>20) == uint32(2147483648)>>20 {
-		res.ErrMessage = append(res.ErrMessage, "EepromFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>21) == uint32(2147483648)>>21 {
-		res.ErrMessage = append(res.ErrMessage, "RelayFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>22) == uint32(2147483648)>>22 {
-		res.ErrMessage = append(res.ErrMessage, "SampleConsistenceFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>23) == uint32(2147483648)>>23 {
-		res.ErrMessage = append(res.ErrMessage, "ResidualCurrent_DeviceFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>24) == uint32(2147483648)>>24 {
-		res.ErrMessage = append(res.ErrMessage, "BIT24")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>25) == uint32(2147483648)>>25 {
-		res.ErrMessage = append(res.ErrMessage, "BIT25")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>26) == uint32(2147483648)>>26 {
-		res.ErrMessage = append(res.ErrMessage, "BIT26")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>27) == uint32(2147483648)>>27 {
-		res.ErrMessage = append(res.ErrMessage, "BIT27")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>28) == uint32(2147483648)>>28 {
-		res.ErrMessage = append(res.ErrMessage, "BIT28")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>29) == uint32(2147483648)>>29 {
-		res.ErrMessage = append(res.ErrMessage, "DCI_DeviceFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>30) == uint32(2147483648)>>30 {
-		res.ErrMessage = append(res.ErrMessage, "OtherDeviceFault")
-	}
-	if in.ErrMessage&(uint32(2147483648)>>31) == uint32(2147483648)>>31 {
-		res.ErrMessage = append(res.ErrMessage, "BIT31")
-	}
 	return res
 }
 
@@ -527,6 +520,13 @@ func NormalInfoResponseFromData(data []byte) (NormalInfoResponse, error) {
 	return result, nil
 }
 
+// Data returns the 50 byte data of the 0x82 response, the inverse of NormalInfoResponseFromData
+func (r NormalInfoResponse) Data() []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, r) // the unused field is written as zeros
+	return buf.Bytes()
+}
+
 // 0x03
 func InverterInfoRequest(address byte) *Packet {
 	p := DefaultPacket()
@@ -580,3 +580,370 @@ func InverterInfoResponseFromData(data []byte) (InverterInfoResponse, error) {
 	}
 	return result, nil
 }
+
+// Data returns the 58 byte data of the 0x83 response, the inverse of InverterInfoResponseFromData.
+// Values are padded with spaces or truncated to the width of their field.
+func (r InverterInfoResponse) Data() []byte {
+	data := []byte{r.Phase}
+	for _, f := range []struct {
+		value string
+		width int
+	}{{r.RatedPower, 6}, {r.FirmwareVersion, 5}, {r.ModuleName, 14}, {r.FactoryName, 14}, {r.SerialNumber, 14}, {r.RatedBusVoltage, 4}} {
+		value := []byte(f.value + strings.Repeat(" ", f.width))
+		data = append(data, value[:f.width]...)
+	}
+	return data
+}
+
+// 0x04
+func ConfigRequest(address byte) *Packet {
+	p := DefaultPacket()
+	p.Destination = uint16FromBytes([2]byte{0x00, address})
+	p.ControlCode = ControlCodeRead
+	p.FunctionCode = 0x04
+	return p
+}
+
+// 0x84
+func ParseConfigResponse(body []byte) (ConfigResponse, error) {
+	p, err := ParsePacket(body)
+	if err != nil {
+		return ConfigResponse{}, err
+	}
+
+	// Control code should be 0x11
+	if p.ControlCode != ControlCodeRead {
+		return ConfigResponse{}, fmt.Errorf("%w: Expected %X, got %X", ErrUnexpectedControlCode, ControlCodeRead, p.ControlCode)
+	}
+	// Function code should be 0x84
+	if p.FunctionCode != 0x84 {
+		return ConfigResponse{}, fmt.Errorf("%w: Expected 0x84, got %X", ErrUnexpectedFunctionCode, p.FunctionCode)
+	}
+
+	return ConfigResponseFromData(p.Data)
+}
+
+type ConfigResponse struct {
+	VpvStart      uint16 // 0.1V PV start voltage
+	TimeStart     uint16 // s, Time to wait before connecting to the grid
+	VacMin        uint16 // 0.1V Grid voltage lower limit
+	VacMax        uint16 // 0.1V Grid voltage upper limit
+	FacMin        uint16 // 0.01Hz Grid frequency lower limit
+	FacMax        uint16 // 0.01Hz Grid frequency upper limit
+	DciLimit      uint16 // mA, DC injection limit
+	VacMinSlow    uint16 // 0.1V Grid voltage lower limit (slow protection)
+	VacMaxSlow    uint16 // 0.1V Grid voltage upper limit (slow protection)
+	FacMinSlow    uint16 // 0.01Hz Grid frequency lower limit (slow protection)
+	FacMaxSlow    uint16 // 0.01Hz Grid frequency upper limit (slow protection)
+	Vac10MinAvg   uint16 // 0.1V Grid voltage limit for the 10 minute average
+	ReconnectTime uint16 // s, Time to wait before reconnecting after a grid fault
+	PowerLimit    uint16 // %, Export power limit as percentage of rated power
+	SafetyCountry uint16 // Safety (country) code
+}
+
+type NormalizedConfigResponse struct {
+	VpvStart      float64 // 0.1V -> V
+	TimeStart     uint16  // s
+	VacMin        float64 // 0.1V -> V
+	VacMax        float64 // 0.1V -> V
+	FacMin        float64 // 0.01Hz -> Hz
+	FacMax        float64 // 0.01Hz -> Hz
+	DciLimit      float64 // mA -> A
+	VacMinSlow    float64 // 0.1V -> V
+	VacMaxSlow    float64 // 0.1V -> V
+	FacMinSlow    float64 // 0.01Hz -> Hz
+	FacMaxSlow    float64 // 0.01Hz -> Hz
+	Vac10MinAvg   float64 // 0.1V -> V
+	ReconnectTime uint16  // s
+	PowerLimit    uint16  // %
+	SafetyCountry uint16  // Safety (country) code
+}
+
+func NormalizeConfigResponse(in ConfigResponse) NormalizedConfigResponse {
+	return NormalizedConfigResponse{
+		VpvStart:      float64(in.VpvStart) / 10,
+		TimeStart:     in.TimeStart,
+		VacMin:        float64(in.VacMin) / 10,
+		VacMax:        float64(in.VacMax) / 10,
+		FacMin:        float64(in.FacMin) / 100,
+		FacMax:        float64(in.FacMax) / 100,
+		DciLimit:      float64(in.DciLimit) / 1000,
+		VacMinSlow:    float64(in.VacMinSlow) / 10,
+		VacMaxSlow:    float64(in.VacMaxSlow) / 10,
+		FacMinSlow:    float64(in.FacMinSlow) / 100,
+		FacMaxSlow:    float64(in.FacMaxSlow) / 100,
+		Vac10MinAvg:   float64(in.Vac10MinAvg) / 10,
+		ReconnectTime: in.ReconnectTime,
+		PowerLimit:    in.PowerLimit,
+		SafetyCountry: in.SafetyCountry,
+	}
+}
+
+func ConfigResponseFromData(data []byte) (ConfigResponse, error) {
+	if len(data) != 30 {
+		return ConfigResponse{}, fmt.Errorf("%w: expected length of 30, got %d", ErrInvalidBody, len(data))
+	}
+	result := ConfigResponse{
+		VpvStart:      uint16FromBytes(*(*[2]byte)(data[0:2])),
+		TimeStart:     uint16FromBytes(*(*[2]byte)(data[2:4])),
+		VacMin:        uint16FromBytes(*(*[2]byte)(data[4:6])),
+		VacMax:        uint16FromBytes(*(*[2]byte)(data[6:8])),
+		FacMin:        uint16FromBytes(*(*[2]byte)(data[8:10])),
+		FacMax:        uint16FromBytes(*(*[2]byte)(data[10:12])),
+		DciLimit:      uint16FromBytes(*(*[2]byte)(data[12:14])),
+		VacMinSlow:    uint16FromBytes(*(*[2]byte)(data[14:16])),
+		VacMaxSlow:    uint16FromBytes(*(*[2]byte)(data[16:18])),
+		FacMinSlow:    uint16FromBytes(*(*[2]byte)(data[18:20])),
+		FacMaxSlow:    uint16FromBytes(*(*[2]byte)(data[20:22])),
+		Vac10MinAvg:   uint16FromBytes(*(*[2]byte)(data[22:24])),
+		ReconnectTime: uint16FromBytes(*(*[2]byte)(data[24:26])),
+		PowerLimit:    uint16FromBytes(*(*[2]byte)(data[26:28])),
+		SafetyCountry: uint16FromBytes(*(*[2]byte)(data[28:30])),
+	}
+	return result, nil
+}
+
+// Data returns the 30 byte data of the 0x84 response, the inverse of ConfigResponseFromData
+func (r ConfigResponse) Data() []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, r)
+	return buf.Bytes()
+}
+
+/*
+-------------------------------------------------------------------------------
+----- Calls related to inverter configuration
+-------------------------------------------------------------------------------
+Control code: 0x12
+Function codes
+
+0x01..0x0E	Client -> Inverter	Write config parameter (see ConfigParameter)
+0x81..0x8E	Inverter -> Client	ACK / NOACK
+
+Each write carries a single uint16 value in the raw unit of the matching
+ConfigResponse field. The safety (country) code can not be written over RS485.
+*/
+
+// ConfigParameter is the function code used to write a single config value
+type ConfigParameter byte
+
+const (
+	ConfigVpvStart      ConfigParameter = 0x01
+	ConfigTimeStart     ConfigParameter = 0x02
+	ConfigVacMin        ConfigParameter = 0x03
+	ConfigVacMax        ConfigParameter = 0x04
+	ConfigFacMin        ConfigParameter = 0x05
+	ConfigFacMax        ConfigParameter = 0x06
+	ConfigDciLimit      ConfigParameter = 0x07
+	ConfigVacMinSlow    ConfigParameter = 0x08
+	ConfigVacMaxSlow    ConfigParameter = 0x09
+	ConfigFacMinSlow    ConfigParameter = 0x0A
+	ConfigFacMaxSlow    ConfigParameter = 0x0B
+	ConfigVac10MinAvg   ConfigParameter = 0x0C
+	ConfigReconnectTime ConfigParameter = 0x0D
+	ConfigPowerLimit    ConfigParameter = 0x0E
+)
+
+var configParameters = []struct {
+	param ConfigParameter
+	name  string
+	scale float64 // raw value = normalized value * scale
+}{
+	{ConfigVpvStart, "VpvStart", 10},
+	{ConfigTimeStart, "TimeStart", 1},
+	{ConfigVacMin, "VacMin", 10},
+	{ConfigVacMax, "VacMax", 10},
+	{ConfigFacMin, "FacMin", 100},
+	{ConfigFacMax, "FacMax", 100},
+	{ConfigDciLimit, "DciLimit", 1000},
+	{ConfigVacMinSlow, "VacMinSlow", 10},
+	{ConfigVacMaxSlow, "VacMaxSlow", 10},
+	{ConfigFacMinSlow, "FacMinSlow", 100},
+	{ConfigFacMaxSlow, "FacMaxSlow", 100},
+	{ConfigVac10MinAvg, "Vac10MinAvg", 10},
+	{ConfigReconnectTime, "ReconnectTime", 1},
+	{ConfigPowerLimit, "PowerLimit", 1},
+}
+
+// ConfigParameters returns all writable config parameters
+func ConfigParameters() []ConfigParameter {
+	res := make([]ConfigParameter, 0, len(configParameters))
+	for _, p := range configParameters {
+		res = append(res, p.param)
+	}
+	return res
+}
+
+// ConfigParameterByName returns the parameter matching a ConfigResponse field name (case insensitive)
+func ConfigParameterByName(name string) (ConfigParameter, error) {
+	for _, p := range configParameters {
+		if strings.EqualFold(p.name, name) {
+			return p.param, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownConfigParameter, name)
+}
+
+// String returns the name of the ConfigResponse field written by this parameter
+func (c ConfigParameter) String() string {
+	for _, p := range configParameters {
+		if p.param == c {
+			return p.name
+		}
+	}
+	return fmt.Sprintf("ConfigParameter(0x%02X)", byte(c))
+}
+
+// RawValue converts a value in normalized units (V, Hz, A, s, %) to the raw value sent to the inverter
+func (c ConfigParameter) RawValue(normalized float64) (uint16, error) {
+	for _, p := range configParameters {
+		if p.param != c {
+			continue
+		}
+		raw := math.Round(normalized * p.scale)
+		if raw < 0 || raw > math.MaxUint16 {
+			return 0, fmt.Errorf("%w: %v out of range for %s", ErrInvalidConfigValue, normalized, p.name)
+		}
+		return uint16(raw), nil
+	}
+	return 0, fmt.Errorf("%w: 0x%02X", ErrUnknownConfigParameter, byte(c))
+}
+
+// normalize converts a raw value to normalized units, the inverse of RawValue
+func (c ConfigParameter) normalize(raw uint16) float64 {
+	for _, p := range configParameters {
+		if p.param == c {
+			return float64(raw) / p.scale
+		}
+	}
+	return float64(raw)
+}
+
+// 0x01..0x0E
+func WriteConfigRequest(address byte, param ConfigParameter, value uint16) *Packet {
+	p := DefaultPacket()
+	p.Destination = uint16FromBytes([2]byte{0x00, address})
+	p.ControlCode = ControlCodeWrite
+	p.FunctionCode = byte(param)
+	p.Data = bytesFromUint16(value)
+	return p
+}
+
+// 0x81..0x8E
+func ParseWriteConfigResponse(body []byte, param ConfigParameter) error {
+	return parseAckResponse(body, ControlCodeWrite, byte(param)|0x80)
+}
+
+// parseAckResponse checks that body is an ACK for the given control and function code.
+// NOACK responses are reported as ErrNOACK.
+func parseAckResponse(body []byte, controlCode, functionCode byte) error {
+	p, err := ParsePacket(body)
+	if err != nil {
+		return err
+	}
+
+	if p.ControlCode != controlCode {
+		return fmt.Errorf("%w: Expected %X, got %X", ErrUnexpectedControlCode, controlCode, p.ControlCode)
+	}
+	if p.FunctionCode != functionCode {
+		return fmt.Errorf("%w: Expected %X, got %X", ErrUnexpectedFunctionCode, functionCode, p.FunctionCode)
+	}
+	// Response should be ACK
+	if len(p.Data) != 1 {
+		return fmt.Errorf("%w: Expected data length 1, got %d", ErrInvalidBody, len(p.Data))
+	}
+	if p.Data[0] == StatusNOACK {
+		return ErrNOACK
+	}
+	if p.Data[0] != StatusACK {
+		return fmt.Errorf("%w: expected ACK (%X) or NOACK (%X), got %X", ErrInvalidBody, StatusACK, StatusNOACK, p.Data[0])
+	}
+
+	return nil
+}
+
+// ConfigChange describes a config field that differs between two config responses
+type ConfigChange struct {
+	Field  string
+	Before float64
+	After  float64
+}
+
+// DiffConfig returns the fields that differ between two (normalized) config responses
+func DiffConfig(before, after NormalizedConfigResponse) []ConfigChange {
+	changes := []ConfigChange{}
+	b := reflect.ValueOf(before)
+	a := reflect.ValueOf(after)
+	for i := 0; i < b.NumField(); i++ {
+		bv, av := toFloat(b.Field(i)), toFloat(a.Field(i))
+		if bv != av {
+			changes = append(changes, ConfigChange{Field: b.Type().Field(i).Name, Before: bv, After: av})
+		}
+	}
+	return changes
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	}
+	return 0
+}
+
+/*
+-------------------------------------------------------------------------------
+----- Calls related to executing commands
+-------------------------------------------------------------------------------
+Control code: 0x13
+Function codes
+
+0x01	Client -> Inverter	Power on (resume exporting power)
+0x81	Inverter -> Client	ACK / NOACK
+0x02	Client -> Inverter	Power off (stop exporting power)
+0x82	Inverter -> Client	ACK / NOACK
+0x03	Client -> Inverter	Restart
+0x83	Inverter -> Client	ACK / NOACK
+0x04	Client -> Inverter	Start self-test (Mode becomes 6: Selftest)
+0x84	Inverter -> Client	ACK / NOACK
+*/
+
+// ExecuteAction is the function code of a command executed by the inverter
+type ExecuteAction byte
+
+const (
+	ExecutePowerOn  ExecuteAction = 0x01
+	ExecutePowerOff ExecuteAction = 0x02
+	ExecuteRestart  ExecuteAction = 0x03
+	ExecuteSelftest ExecuteAction = 0x04
+)
+
+func (a ExecuteAction) String() string {
+	switch a {
+	case ExecutePowerOn:
+		return "PowerOn"
+	case ExecutePowerOff:
+		return "PowerOff"
+	case ExecuteRestart:
+		return "Restart"
+	case ExecuteSelftest:
+		return "Selftest"
+	}
+	return fmt.Sprintf("ExecuteAction(0x%02X)", byte(a))
+}
+
+// 0x01..0x04
+func ExecuteRequest(address byte, action ExecuteAction) *Packet {
+	p := DefaultPacket()
+	p.Destination = uint16FromBytes([2]byte{0x00, address})
+	p.ControlCode = ControlCodeExecute
+	p.FunctionCode = byte(action)
+	return p
+}
+
+// 0x81..0x84
+func ParseExecuteResponse(body []byte, action ExecuteAction) error {
+	return parseAckResponse(body, ControlCodeExecute, byte(action)|0x80)
+}
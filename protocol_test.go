@@ -71,3 +71,209 @@ func TestNormalizeNormalInfoResponse(t *testing.T) {
 	require.Contains(t, out.ErrMessage, "BIT27")
 	require.Contains(t, out.ErrMessage, "BIT31")
 }
+
+func TestNormalizedNormalInfoResponseFields(t *testing.T) {
+	fields := NormalizeInfoResponse(NormalInfoResponse{Power: 1500, EnergyTotal: 123, Mode: 2}).Fields()
+	require.Len(t, fields, 21)
+	require.Equal(t, Field{Name: "Temperature", Unit: "°C", Value: uint16(0)}, fields[0])
+	require.Equal(t, Field{Name: "Power", Unit: "W", Value: uint16(1500)}, fields[9])
+	require.Equal(t, Field{Name: "EnergyTotal", Unit: "kWh", Value: 12.3}, fields[10])
+	require.Equal(t, Field{Name: "Mode", Value: "Normal"}, fields[12])
+	require.Equal(t, "ErrMessage", fields[20].Name)
+}
+
+func TestNormalInfoResponseData(t *testing.T) {
+	in := NormalInfoResponse{Temperature: 40, Power: 1500, EnergyTotal: 70000, TimeTotal: 1234, Mode: 2, GFCFault: 7, ErrMessage: 1 << 31}
+	data := in.Data()
+	require.Len(t, data, 50)
+	require.Equal(t, []byte{0x05, 0xDC, 0x00, 0x00, 0x00, 0x01, 0x11, 0x70}, data[18:26])
+	out, err := NormalInfoResponseFromData(data)
+	require.NoError(t, err)
+	require.Equal(t, in, out)
+}
+
+func TestInverterInfoResponseData(t *testing.T) {
+	in := InverterInfoResponse{Phase: 1, RatedPower: "  3000", FirmwareVersion: "1.20 ", ModuleName: "X1-3.0-S-D    ", FactoryName: "SolaxPower    ", SerialNumber: "XB3002I1234567", RatedBusVoltage: "380 "}
+	data := in.Data()
+	require.Len(t, data, 58)
+	out, err := InverterInfoResponseFromData(data)
+	require.NoError(t, err)
+	require.Equal(t, in, out)
+
+	// short values are padded, long values truncated
+	out, err = InverterInfoResponseFromData(InverterInfoResponse{ModuleName: "X1", SerialNumber: "XB3002I12345678"}.Data())
+	require.NoError(t, err)
+	require.Equal(t, "X1            ", out.ModuleName)
+	require.Equal(t, "XB3002I1234567", out.SerialNumber)
+}
+
+func TestConfigResponseData(t *testing.T) {
+	in := ConfigResponse{VpvStart: 900, VacMin: 2000, FacMax: 5150, PowerLimit: 100, SafetyCountry: 6}
+	data := in.Data()
+	require.Len(t, data, 30)
+	out, err := ConfigResponseFromData(data)
+	require.NoError(t, err)
+	require.Equal(t, in, out)
+}
+
+func TestConfigRequest(t *testing.T) {
+	body, err := ConfigRequest(0x0A).Bytes()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xAA, 0x55, 0x00, 0x00, 0x00, 0x0A, 0x11, 0x04, 0x00, 0x01, 0x1E}, body)
+}
+
+func TestParseConfigResponse(t *testing.T) {
+	p := DefaultPacket()
+	p.Source = 0x000A
+	p.ControlCode = ControlCodeRead
+	p.FunctionCode = 0x84
+	p.Data = []byte{
+		0x03, 0x84, // VpvStart 90.0V
+		0x00, 0x3C, // TimeStart 60s
+		0x07, 0xD0, // VacMin 200.0V
+		0x0A, 0x16, // VacMax 258.2V
+		0x12, 0xC0, // FacMin 48.00Hz
+		0x14, 0x1E, // FacMax 51.50Hz
+		0x00, 0xFA, // DciLimit 250mA
+		0x08, 0x34, // VacMinSlow 210.0V
+		0x09, 0xF6, // VacMaxSlow 255.0V
+		0x12, 0xF2, // FacMinSlow 48.50Hz
+		0x13, 0xEC, // FacMaxSlow 51.00Hz
+		0x09, 0xEC, // Vac10MinAvg 254.0V
+		0x01, 0x2C, // ReconnectTime 300s
+		0x00, 0x64, // PowerLimit 100%
+		0x00, 0x06, // SafetyCountry 6
+	}
+	body, err := p.Bytes()
+	require.NoError(t, err)
+
+	res, err := ParseConfigResponse(body)
+	require.NoError(t, err)
+	require.Equal(t, uint16(2000), res.VacMin)
+	require.Equal(t, uint16(6), res.SafetyCountry)
+
+	out := NormalizeConfigResponse(res)
+	require.Equal(t, 90.0, out.VpvStart)
+	require.Equal(t, 258.2, out.VacMax)
+	require.Equal(t, 48.0, out.FacMin)
+	require.Equal(t, 51.5, out.FacMax)
+	require.Equal(t, 0.25, out.DciLimit)
+	require.Equal(t, uint16(300), out.ReconnectTime)
+	require.Equal(t, uint16(100), out.PowerLimit)
+
+	t.Run("Wrong function code", func(t *testing.T) {
+		p.FunctionCode = 0x83
+		body, err := p.Bytes()
+		require.NoError(t, err)
+		_, err = ParseConfigResponse(body)
+		require.ErrorIs(t, err, ErrUnexpectedFunctionCode)
+	})
+
+	t.Run("Short data", func(t *testing.T) {
+		_, err := ConfigResponseFromData(make([]byte, 20))
+		require.ErrorIs(t, err, ErrInvalidBody)
+	})
+}
+
+func TestWriteConfigRequest(t *testing.T) {
+	raw, err := ConfigPowerLimit.RawValue(70)
+	require.NoError(t, err)
+	body, err := WriteConfigRequest(0x0A, ConfigPowerLimit, raw).Bytes()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xAA, 0x55, 0x00, 0x00, 0x00, 0x0A, 0x12, 0x0E, 0x02, 0x00, 0x46, 0x01, 0x71}, body)
+
+	raw, err = ConfigFacMax.RawValue(51.5)
+	require.NoError(t, err)
+	require.Equal(t, uint16(5150), raw)
+
+	_, err = ConfigVacMax.RawValue(-1)
+	require.ErrorIs(t, err, ErrInvalidConfigValue)
+}
+
+func TestConfigParameterByName(t *testing.T) {
+	p, err := ConfigParameterByName("powerlimit")
+	require.NoError(t, err)
+	require.Equal(t, ConfigPowerLimit, p)
+	require.Equal(t, "PowerLimit", p.String())
+
+	_, err = ConfigParameterByName("SafetyCountry")
+	require.ErrorIs(t, err, ErrUnknownConfigParameter)
+}
+
+func TestParseWriteConfigResponse(t *testing.T) {
+	p := DefaultPacket()
+	p.ControlCode = ControlCodeWrite
+	p.FunctionCode = 0x8E
+	p.Data = []byte{StatusACK}
+	body, err := p.Bytes()
+	require.NoError(t, err)
+	require.NoError(t, ParseWriteConfigResponse(body, ConfigPowerLimit))
+	require.ErrorIs(t, ParseWriteConfigResponse(body, ConfigVacMax), ErrUnexpectedFunctionCode)
+
+	p.Data = []byte{StatusNOACK}
+	body, err = p.Bytes()
+	require.NoError(t, err)
+	require.ErrorIs(t, ParseWriteConfigResponse(body, ConfigPowerLimit), ErrNOACK)
+}
+
+func TestDiffConfig(t *testing.T) {
+	before := NormalizeConfigResponse(ConfigResponse{VacMax: 2530, PowerLimit: 100})
+	after := NormalizeConfigResponse(ConfigResponse{VacMax: 2530, PowerLimit: 70})
+	changes := DiffConfig(before, after)
+	require.Equal(t, []ConfigChange{{Field: "PowerLimit", Before: 100, After: 70}}, changes)
+}
+
+func TestExecuteRequest(t *testing.T) {
+	body, err := ExecuteRequest(0x0A, ExecutePowerOff).Bytes()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xAA, 0x55, 0x00, 0x00, 0x00, 0x0A, 0x13, 0x02, 0x00, 0x01, 0x1E}, body)
+}
+
+func TestParseExecuteResponse(t *testing.T) {
+	p := DefaultPacket()
+	p.ControlCode = ControlCodeExecute
+	p.FunctionCode = 0x84
+	p.Data = []byte{StatusACK}
+	body, err := p.Bytes()
+	require.NoError(t, err)
+	require.NoError(t, ParseExecuteResponse(body, ExecuteSelftest))
+	require.ErrorIs(t, ParseExecuteResponse(body, ExecuteRestart), ErrUnexpectedFunctionCode)
+
+	p.ControlCode = ControlCodeWrite
+	body, err = p.Bytes()
+	require.NoError(t, err)
+	require.ErrorIs(t, ParseExecuteResponse(body, ExecuteSelftest), ErrUnexpectedControlCode)
+}
+
+func TestReconnectRemovedInverter(t *testing.T) {
+	serial := []byte("XB3002I1234567")
+	body, err := ReconnectRemovedInverterRequest(serial, 0x0A).Bytes()
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0xAA, 0x55, 0x00, 0x00, 0x00, 0x00, 0x10, 0x03, 0x0F,
+		0x58, 0x42, 0x33, 0x30, 0x30, 0x32, 0x49, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x0A,
+		0x04, 0x3F,
+	}, body)
+	require.Equal(t, []byte("XB3002I1234567"), serial, "request must not modify the serial")
+
+	resp := []byte{0xAA, 0x55, 0x00, 0x0A, 0x00, 0x00, 0x10, 0x83, 0x01, 0x06, 0x01, 0xA3}
+	require.NoError(t, ParseReconnectRemovedInverterResponse(resp))
+	require.ErrorIs(t, ParseReregisterInverterResponse(resp), ErrUnexpectedFunctionCode)
+
+	resp = []byte{0xAA, 0x55, 0x00, 0x0A, 0x00, 0x00, 0x10, 0x83, 0x01, 0x15, 0x01, 0xB2}
+	require.ErrorIs(t, ParseReconnectRemovedInverterResponse(resp), ErrNOACK)
+}
+
+func TestReregisterInverter(t *testing.T) {
+	serial := []byte("XB3002I1234567")
+	body, err := ReregisterInverterRequest(serial, 0x0B).Bytes()
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		0xAA, 0x55, 0x00, 0x00, 0x00, 0x00, 0x10, 0x04, 0x0F,
+		0x58, 0x42, 0x33, 0x30, 0x30, 0x32, 0x49, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x0B,
+		0x04, 0x41,
+	}, body)
+
+	resp := []byte{0xAA, 0x55, 0x00, 0x0B, 0x00, 0x00, 0x10, 0x84, 0x01, 0x06, 0x01, 0xA5}
+	require.NoError(t, ParseReregisterInverterResponse(resp))
+}
@@ -0,0 +1,274 @@
+package simulator
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+)
+
+/*
+Connection is an in-memory solax.Connection to a bus of simulated inverters,
+for use with solax.NewClientWithConnection.
+
+Every Write must hold a complete request, as written by the Client. Responses
+are available to Read right away, a Read without pending bytes returns io.EOF
+like a serial port with a read timeout does. When several inverters answer the
+same request (e.g. unregistered inverters answering 0x00) their frames overlap:
+the bytes of the responses are interleaved, like on a real bus the result is
+garbage with a checksum mismatch.
+
+With AnswerSlots > 1, every unregistered inverter answers a 0x00 query in a
+random one of AnswerSlots slots of SlotTime, like inverters that delay their
+answer to a broadcast. Only answers in the same slot overlap.
+
+Faults injects line faults into the responses, Faults.Rand is also the source
+of the answer slots.
+*/
+type Connection struct {
+	Inverters   []*Inverter
+	Faults      LineFaults
+	AnswerSlots int           // Slots for the answers to a 0x00 query, 0 or 1: all unregistered inverters answer at once
+	SlotTime    time.Duration // Duration of an answer slot, defaults to 20ms
+
+	mu      sync.Mutex
+	pending []chunk
+	closed  bool
+}
+
+// chunk is a part of a response that can be read from at
+type chunk struct {
+	at   time.Time
+	data []byte
+}
+
+/*
+LineFaults are injected into the responses on a Connection, e.g. to test
+retries and resynchronisation. Every fault happens to a response with its
+probability, from 0 (never) to 1 (always).
+*/
+type LineFaults struct {
+	CorruptChecksum float64 // Flip the bits of the checksum
+	DropByte        float64 // Drop a random byte
+	Delay           float64 // Deliver the response DelayTime later, e.g. past the Client WaitTime
+	DelayTime       time.Duration
+	Split           float64 // Deliver the response in two parts at a random position, the second part SplitTime later
+	SplitTime       time.Duration
+	Rand            *rand.Rand // Source of the faults, e.g. seeded for reproducible tests. Defaults to math/rand.
+}
+
+func (f LineFaults) happens(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	if f.Rand == nil {
+		return rand.Float64() < probability
+	}
+	return f.Rand.Float64() < probability
+}
+
+func (f LineFaults) intn(n int) int {
+	if f.Rand == nil {
+		return rand.Intn(n)
+	}
+	return f.Rand.Intn(n)
+}
+
+func NewConnection(inverters ...*Inverter) *Connection {
+	return &Connection{Inverters: inverters}
+}
+
+func (c *Connection) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	req, err := solax.ParsePacket(p)
+	if err != nil {
+		return len(p), nil // an inverter ignores what it can't parse
+	}
+	slots := 1
+	if c.AnswerSlots > 1 && req.ControlCode == solax.ControlCodeRegister && req.FunctionCode == 0x00 {
+		slots = c.AnswerSlots
+	}
+	responses := make([][][]byte, slots)
+	for _, inv := range c.Inverters {
+		if resp := inv.Respond(req); resp != nil {
+			body, err := resp.Bytes()
+			if err != nil {
+				return 0, err
+			}
+			slot := 0
+			if slots > 1 {
+				slot = c.Faults.intn(slots)
+			}
+			responses[slot] = append(responses[slot], body)
+		}
+	}
+	slotTime := c.SlotTime
+	if slotTime == 0 {
+		slotTime = 20 * time.Millisecond
+	}
+	now := time.Now()
+	for slot, r := range responses {
+		if len(r) > 0 {
+			c.deliver(now.Add(time.Duration(slot)*slotTime), overlap(r))
+		}
+	}
+	return len(p), nil
+}
+
+// deliver queues a response sent at after injecting the line faults
+func (c *Connection) deliver(at time.Time, body []byte) {
+	f := c.Faults
+	if f.happens(f.CorruptChecksum) {
+		body[len(body)-1] ^= 0xFF
+		body[len(body)-2] ^= 0xFF
+	}
+	if f.happens(f.DropByte) {
+		i := f.intn(len(body))
+		body = append(body[:i], body[i+1:]...)
+	}
+	if f.happens(f.Delay) {
+		at = at.Add(f.DelayTime)
+	}
+	if f.happens(f.Split) && len(body) > 1 {
+		i := 1 + f.intn(len(body)-1)
+		c.queue(chunk{at, body[:i]})
+		c.queue(chunk{at.Add(f.SplitTime), body[i:]})
+		return
+	}
+	c.queue(chunk{at, body})
+}
+
+// queue adds ch to the pending chunks, which are kept in the order they can be read
+func (c *Connection) queue(ch chunk) {
+	i := len(c.pending)
+	for i > 0 && c.pending[i-1].at.After(ch.at) {
+		i--
+	}
+	c.pending = append(c.pending[:i], append([]chunk{ch}, c.pending[i:]...)...)
+}
+
+// overlap interleaves the bytes of responses sent at the same time
+func overlap(responses [][]byte) []byte {
+	if len(responses) == 1 {
+		return responses[0]
+	}
+	out := []byte{}
+	for i := 0; ; i++ {
+		done := true
+		for _, r := range responses {
+			if i < len(r) {
+				out = append(out, r[i])
+				done = false
+			}
+		}
+		if done {
+			return out
+		}
+	}
+}
+
+func (c *Connection) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	now := time.Now()
+	n := 0
+	for len(c.pending) > 0 && !c.pending[0].at.After(now) && n < len(p) {
+		m := copy(p[n:], c.pending[0].data)
+		n += m
+		c.pending[0].data = c.pending[0].data[m:]
+		if len(c.pending[0].data) == 0 {
+			c.pending = c.pending[1:]
+		}
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// Flush discards the bytes that arrived, delayed responses still arrive later
+func (c *Connection) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for len(c.pending) > 0 && !c.pending[0].at.After(now) {
+		c.pending = c.pending[1:]
+	}
+	return nil
+}
+
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// ServeConn answers the requests read from rw until reading or writing fails, e.g. on a socket or pseudo-terminal.
+// Frames with a checksum mismatch are ignored. The end of the stream is reported as io.EOF.
+func (inv *Inverter) ServeConn(rw io.ReadWriter) error {
+	frames := solax.NewFrameReader(eofReader{rw})
+	for {
+		frame, err := frames.ReadFrame(time.Now().Add(time.Minute))
+		if errors.Is(err, errEOF) {
+			return io.EOF
+		}
+		if err != nil && !errors.Is(err, solax.ErrInvalidBody) {
+			return err
+		}
+		req, err := solax.ParsePacket(frame)
+		if err != nil {
+			continue // a corrupt frame, or the deadline passed
+		}
+		if resp := inv.Respond(req); resp != nil {
+			body, err := resp.Bytes()
+			if err != nil {
+				return err
+			}
+			if _, err := rw.Write(body); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Serve accepts connections on l and serves each with ServeConn, e.g. to be used as
+// an RS485-to-Ethernet gateway (solax -d tcp://host:port). It returns when l is closed.
+func (inv *Inverter) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			inv.ServeConn(conn)
+		}()
+	}
+}
+
+var errEOF = errors.New("end of stream")
+
+// eofReader reports io.EOF as errEOF, the FrameReader treats io.EOF as a read timeout and would keep reading
+type eofReader struct {
+	r io.Reader
+}
+
+func (e eofReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		err = errEOF
+	}
+	return n, err
+}
@@ -0,0 +1,92 @@
+package simulator
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedBus(t *testing.T) {
+	a, b, c := NewInverter("XB3002IAAAAAAA", 0), NewInverter("XB3002IBBBBBBB", 0), NewInverter("XB3002ICCCCCCC", 1)
+	conn := NewConnection(a, b, c)
+	client, _ := solax.NewClientWithConnection(conn)
+	client.WaitTime = 50 * time.Millisecond
+
+	// both unregistered inverters answer at once
+	_, err := client.FindUnregisteredInverter()
+	require.ErrorIs(t, err, solax.ErrInvalidBody)
+	registered, err := client.DiscoverAndRegisterAll(context.Background(), solax.DiscoverOptions{Used: []byte{1}, MaxCollisions: 3})
+	require.ErrorIs(t, err, solax.ErrInvalidBody)
+	require.Empty(t, registered)
+
+	// answering in random slots, the collisions are resolved
+	conn.AnswerSlots, conn.SlotTime = 4, 10*time.Millisecond
+	conn.Faults.Rand = rand.New(rand.NewSource(1))
+	registered, err = client.DiscoverAndRegisterAll(context.Background(), solax.DiscoverOptions{Used: []byte{1}})
+	require.NoError(t, err)
+	require.Len(t, registered, 2)
+	require.ElementsMatch(t, []byte{2, 3}, []byte{a.Address, b.Address})
+
+	for address, serial := range map[byte]string{1: c.Serial, a.Address: a.Serial, b.Address: b.Serial} {
+		info, err := client.GetInverterInfo(&solax.Inverter{Address: address})
+		require.NoError(t, err)
+		require.Equal(t, serial, info.SerialNumber)
+	}
+}
+
+func TestLineFaults(t *testing.T) {
+	newClient := func(faults LineFaults) *solax.Client {
+		conn := NewConnection(NewInverter("XB3002I0000001", 1))
+		faults.Rand = rand.New(rand.NewSource(1))
+		conn.Faults = faults
+		client, _ := solax.NewClientWithConnection(conn)
+		client.WaitTime = 50 * time.Millisecond
+		return client
+	}
+	inv := &solax.Inverter{Address: 1}
+
+	t.Run("Corrupt checksum", func(t *testing.T) {
+		client := newClient(LineFaults{CorruptChecksum: 1})
+		_, err := client.GetInfo(inv)
+		require.ErrorIs(t, err, solax.ErrInvalidBody)
+	})
+
+	t.Run("Dropped byte", func(t *testing.T) {
+		client := newClient(LineFaults{DropByte: 1})
+		_, err := client.GetInfo(inv)
+		require.ErrorIs(t, err, solax.ErrInvalidBody)
+	})
+
+	t.Run("Split frames are reassembled", func(t *testing.T) {
+		client := newClient(LineFaults{Split: 1, SplitTime: 10 * time.Millisecond})
+		for i := 0; i < 5; i++ {
+			info, err := client.GetInfo(inv)
+			require.NoError(t, err)
+			require.Equal(t, uint16(2480), info.Power)
+		}
+	})
+
+	t.Run("Delayed past WaitTime", func(t *testing.T) {
+		client := newClient(LineFaults{Delay: 1, DelayTime: 80 * time.Millisecond})
+		_, err := client.GetInfo(inv)
+		require.ErrorIs(t, err, solax.ErrEmptyBody)
+		time.Sleep(50 * time.Millisecond)
+		// the late response is flushed before the next request, which is late again
+		_, err = client.GetInfo(inv)
+		require.ErrorIs(t, err, solax.ErrEmptyBody)
+	})
+
+	t.Run("Retries recover from occasional faults", func(t *testing.T) {
+		client := newClient(LineFaults{CorruptChecksum: 0.3, DropByte: 0.2})
+		client.RetryPolicy = solax.RetryPolicy{MaxAttempts: 10}
+		for i := 0; i < 20; i++ {
+			_, err := client.GetInfo(inv)
+			require.NoError(t, err)
+		}
+		require.Greater(t, client.Stats().Retries, uint64(0))
+	})
+}
@@ -0,0 +1,220 @@
+/*
+Package simulator implements the inverter side of the Solax X1 RS485 protocol.
+
+A simulated Inverter answers registration (0x10), query (0x11), write config
+(0x12) and execute (0x13) requests like a real inverter. It can be used in
+memory through a Connection, which can hold several inverters sharing a bus
+and inject line faults, e.g.
+
+	inv := simulator.NewInverter("XB3002I0000001", 0)
+	client, _ := solax.NewClientWithConnection(simulator.NewConnection(inv))
+
+or served on a socket or pseudo-terminal (see Inverter.Serve, Inverter.ServeConn
+and OpenPTY) to test the solax command without hardware.
+*/
+package simulator
+
+import (
+	"reflect"
+	"sync"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+)
+
+/*
+Inverter is a simulated inverter, its exported fields hold the state and can be
+read from JSON. Change the state of an inverter in use with Update.
+
+Like a real inverter, requests it can't handle (e.g. a query for another address
+or a register request with another serial) are not answered. Requests that it
+understands but rejects (e.g. registering an inverter that is already registered)
+are answered with NOACK.
+*/
+type Inverter struct {
+	Serial  string // 14 characters, also reported as SerialNumber by the specifications query
+	Address byte   // 0 while unregistered
+	Info    solax.NormalInfoResponse
+	Spec    solax.InverterInfoResponse
+	Config  solax.ConfigResponse
+	Script  []solax.NormalInfoResponse `json:",omitempty"` // Replaces Info on every info query, in order. Info keeps the last value once exhausted.
+	Offline bool                       `json:",omitempty"` // Doesn't answer any request, e.g. powered down at night
+
+	mu      sync.Mutex
+	removed byte // Address before the inverter was removed with 0x02, 0 when not removed
+}
+
+// NewInverter returns an inverter with a 3kW spec, a typical grid config and an Info of a sunny day
+func NewInverter(serial string, address byte) *Inverter {
+	return &Inverter{
+		Serial:  serial,
+		Address: address,
+		Info: solax.NormalInfoResponse{
+			Temperature: 38,
+			EnergyToday: 84,
+			Vpv1:        3120,
+			Vpv2:        3085,
+			Apv1:        42,
+			Apv2:        41,
+			Iac:         109,
+			Vac:         2314,
+			Frequency:   5001,
+			Power:       2480,
+			EnergyTotal: 123456,
+			TimeTotal:   8760,
+			Mode:        solax.ModeNormal,
+		},
+		Spec: solax.InverterInfoResponse{
+			Phase:           1,
+			RatedPower:      "  3000",
+			FirmwareVersion: "1.20 ",
+			ModuleName:      "X1-3.0-S-D",
+			FactoryName:     "SolaxPower",
+			SerialNumber:    serial,
+			RatedBusVoltage: "380 ",
+		},
+		Config: solax.ConfigResponse{
+			VpvStart:      900,
+			TimeStart:     60,
+			VacMin:        2000,
+			VacMax:        2582,
+			FacMin:        4800,
+			FacMax:        5150,
+			DciLimit:      250,
+			VacMinSlow:    2100,
+			VacMaxSlow:    2550,
+			FacMinSlow:    4850,
+			FacMaxSlow:    5100,
+			Vac10MinAvg:   2540,
+			ReconnectTime: 300,
+			PowerLimit:    100,
+			SafetyCountry: 6,
+		},
+	}
+}
+
+// Update changes the state of the inverter, e.g. inv.Update(func(inv *Inverter) { inv.Info.Power = 0 })
+func (inv *Inverter) Update(update func(*Inverter)) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	update(inv)
+}
+
+// Respond returns the response of the inverter to req, nil when it doesn't answer
+func (inv *Inverter) Respond(req *solax.Packet) *solax.Packet {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	if inv.Offline {
+		return nil
+	}
+	if req.ControlCode == solax.ControlCodeRegister {
+		return inv.register(req)
+	}
+	// all other requests are addressed to a registered inverter
+	if inv.Address == 0 || req.Destination != uint16(inv.Address) {
+		return nil
+	}
+	switch req.ControlCode {
+	case solax.ControlCodeRead:
+		return inv.read(req)
+	case solax.ControlCodeWrite:
+		return inv.write(req)
+	case solax.ControlCodeExecute:
+		return inv.execute(req)
+	}
+	return nil
+}
+
+func (inv *Inverter) register(req *solax.Packet) *solax.Packet {
+	if req.FunctionCode == 0x00 {
+		if inv.Address != 0 {
+			return nil
+		}
+		return inv.response(solax.ControlCodeRegister, 0x80, []byte(inv.Serial))
+	}
+
+	// the other functions carry the serial followed by the address
+	if len(req.Data) != len(inv.Serial)+1 || string(req.Data[:len(inv.Serial)]) != inv.Serial {
+		return nil
+	}
+	address := req.Data[len(inv.Serial)]
+	ok := false
+	switch req.FunctionCode {
+	case 0x01: // register
+		ok = address != 0 && inv.Address == 0
+	case 0x02: // remove
+		ok = address != 0 && inv.Address == address
+	case 0x03: // reconnect removed
+		ok = address != 0 && inv.removed == address
+	case 0x04: // reregister
+		ok = address != 0
+	default:
+		return nil
+	}
+	switch {
+	case ok && req.FunctionCode == 0x02:
+		inv.Address, inv.removed = 0, address
+	case ok:
+		inv.Address, inv.removed = address, 0
+	}
+	return inv.ack(solax.ControlCodeRegister, req.FunctionCode|0x80, ok)
+}
+
+func (inv *Inverter) read(req *solax.Packet) *solax.Packet {
+	switch req.FunctionCode {
+	case 0x02:
+		if len(inv.Script) > 0 {
+			inv.Info, inv.Script = inv.Script[0], inv.Script[1:]
+		}
+		return inv.response(solax.ControlCodeRead, 0x82, inv.Info.Data())
+	case 0x03:
+		spec := inv.Spec
+		spec.SerialNumber = inv.Serial
+		return inv.response(solax.ControlCodeRead, 0x83, spec.Data())
+	case 0x04:
+		return inv.response(solax.ControlCodeRead, 0x84, inv.Config.Data())
+	}
+	return nil
+}
+
+func (inv *Inverter) write(req *solax.Packet) *solax.Packet {
+	param := solax.ConfigParameter(req.FunctionCode)
+	if _, err := solax.ConfigParameterByName(param.String()); err != nil || len(req.Data) != 2 {
+		return inv.ack(solax.ControlCodeWrite, req.FunctionCode|0x80, false)
+	}
+	value := uint16(req.Data[0])<<8 | uint16(req.Data[1])
+	reflect.ValueOf(&inv.Config).Elem().FieldByName(param.String()).SetUint(uint64(value))
+	return inv.ack(solax.ControlCodeWrite, req.FunctionCode|0x80, true)
+}
+
+func (inv *Inverter) execute(req *solax.Packet) *solax.Packet {
+	switch solax.ExecuteAction(req.FunctionCode) {
+	case solax.ExecutePowerOn:
+		inv.Info.Mode = solax.ModeNormal
+	case solax.ExecutePowerOff:
+		inv.Info.Mode, inv.Info.Power, inv.Info.Iac = 0, 0, 0 // Wait
+	case solax.ExecuteRestart:
+		inv.Info.Mode = 1 // Check
+	case solax.ExecuteSelftest:
+		inv.Info.Mode = 6 // Selftest
+	default:
+		return inv.ack(solax.ControlCodeExecute, req.FunctionCode|0x80, false)
+	}
+	return inv.ack(solax.ControlCodeExecute, req.FunctionCode|0x80, true)
+}
+
+func (inv *Inverter) ack(controlCode, functionCode byte, ok bool) *solax.Packet {
+	if ok {
+		return inv.response(controlCode, functionCode, []byte{solax.StatusACK})
+	}
+	return inv.response(controlCode, functionCode, []byte{solax.StatusNOACK})
+}
+
+func (inv *Inverter) response(controlCode, functionCode byte, data []byte) *solax.Packet {
+	p := solax.DefaultPacket()
+	p.Source = uint16(inv.Address)
+	p.ControlCode = controlCode
+	p.FunctionCode = functionCode
+	p.Data = data
+	return p
+}
@@ -0,0 +1,88 @@
+package simulator
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	inv := NewInverter("XB3002I0000001", 0)
+	client, err := solax.NewClientWithConnection(NewConnection(inv))
+	require.NoError(t, err)
+	client.WaitTime = 50 * time.Millisecond
+
+	found, err := client.FindUnregisteredInverter()
+	require.NoError(t, err)
+	require.Equal(t, []byte("XB3002I0000001"), found.Serial)
+	require.NoError(t, client.RegisterInverter(found, 5))
+	require.Equal(t, byte(5), inv.Address)
+	_, err = client.FindUnregisteredInverter()
+	require.ErrorIs(t, err, solax.ErrNoInverter)
+
+	info, err := client.GetInfo(found)
+	require.NoError(t, err)
+	require.Equal(t, inv.Info, *info)
+	spec, err := client.GetInverterInfo(found)
+	require.NoError(t, err)
+	require.Equal(t, "XB3002I0000001", spec.SerialNumber)
+	require.Equal(t, "X1-3.0-S-D    ", spec.ModuleName)
+
+	changes, err := client.WriteConfig(found, solax.ConfigPowerLimit, 70)
+	require.NoError(t, err)
+	require.Equal(t, []solax.ConfigChange{{Field: "PowerLimit", Before: 100, After: 70}}, changes)
+
+	require.NoError(t, client.PowerOff(found))
+	info, err = client.GetInfo(found)
+	require.NoError(t, err)
+	require.Equal(t, uint16(0), info.Power)
+	require.Equal(t, "Wait", solax.ModeName(info.Mode))
+	require.ErrorIs(t, client.Execute(found, solax.ExecuteAction(0x09)), solax.ErrNOACK)
+
+	// another address doesn't answer
+	_, err = client.GetInfo(&solax.Inverter{Address: 6})
+	require.ErrorIs(t, err, solax.ErrEmptyBody)
+
+	require.NoError(t, client.UnregisterInverter(found))
+	require.Equal(t, byte(0), inv.Address)
+	found.Address = 4
+	require.ErrorIs(t, client.ReconnectRemovedInverter(found), solax.ErrNOACK)
+	found.Address = 5
+	require.NoError(t, client.ReconnectRemovedInverter(found))
+	require.NoError(t, client.ReregisterInverter(found, 7))
+	require.Equal(t, byte(7), inv.Address)
+}
+
+func TestScript(t *testing.T) {
+	inv := NewInverter("XB3002I0000001", 1)
+	inv.Script = []solax.NormalInfoResponse{{Power: 100}, {Power: 200}}
+	client, _ := solax.NewClientWithConnection(NewConnection(inv))
+
+	for _, power := range []uint16{100, 200, 200} {
+		info, err := client.GetInfo(&solax.Inverter{Address: 1})
+		require.NoError(t, err)
+		require.Equal(t, power, info.Power)
+	}
+	inv.Update(func(inv *Inverter) { inv.Info.Power = 300 })
+	info, err := client.GetInfo(&solax.Inverter{Address: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint16(300), info.Power)
+}
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go NewInverter("XB3002I0000001", 3).Serve(l)
+
+	client, err := solax.NewTCPClient(l.Addr().String())
+	require.NoError(t, err)
+	defer client.Conn.Close()
+	info, err := client.GetInfoContext(context.Background(), &solax.Inverter{Address: 3})
+	require.NoError(t, err)
+	require.Equal(t, uint16(2480), info.Power)
+}
@@ -0,0 +1,91 @@
+package simulator
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+/*
+PTY is a pseudo-terminal. The simulator reads and writes the PTY itself (the
+master side), clients open Name like a serial device, e.g. solax -d /dev/pts/3.
+*/
+type PTY struct {
+	*os.File
+	Name string
+
+	slave *os.File // kept open, reading the master fails while no client has the device open
+}
+
+// OpenPTY creates a pseudo-terminal in raw mode
+func OpenPTY() (*PTY, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	var n uint32
+	var unlock int32
+	err = control(master, func(fd uintptr) error {
+		if err := ioctl(fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+			return err
+		}
+		return ioctl(fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	})
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	name := fmt.Sprintf("/dev/pts/%d", n)
+	slave, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	// raw mode (like cfmakeraw), the protocol is binary
+	err = control(slave, func(fd uintptr) error {
+		var t syscall.Termios
+		if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+			return err
+		}
+		t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+		t.Oflag &^= syscall.OPOST
+		t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+		t.Cflag &^= syscall.CSIZE | syscall.PARENB
+		t.Cflag |= syscall.CS8
+		t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 1, 0
+		return ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t)))
+	})
+	if err != nil {
+		slave.Close()
+		master.Close()
+		return nil, err
+	}
+	return &PTY{File: master, Name: name, slave: slave}, nil
+}
+
+func (p *PTY) Close() error {
+	p.slave.Close()
+	return p.File.Close()
+}
+
+// control runs f with the file descriptor of file, without putting the file in blocking mode like File.Fd does
+func control(file *os.File, f func(fd uintptr) error) error {
+	raw, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := raw.Control(func(fd uintptr) { ferr = f(fd) }); err != nil {
+		return err
+	}
+	return ferr
+}
+
+func ioctl(fd, request, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
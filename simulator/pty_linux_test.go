@@ -0,0 +1,32 @@
+package simulator
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	solax "github.com/hectormalot/solax-x1-rs485"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPTY(t *testing.T) {
+	pty, err := OpenPTY()
+	require.NoError(t, err)
+	defer pty.Close()
+	go NewInverter("XB3002I0000001", 2).ServeConn(pty)
+
+	device, err := os.OpenFile(pty.Name, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer device.Close()
+	req, err := solax.NormalInfoRequest(2).Bytes()
+	require.NoError(t, err)
+	_, err = device.Write(req)
+	require.NoError(t, err)
+
+	frames := solax.NewFrameReader(device)
+	resp, err := frames.ReadFrame(time.Now().Add(time.Second))
+	require.NoError(t, err)
+	info, err := solax.ParseNormalInfoResponse(resp)
+	require.NoError(t, err)
+	require.Equal(t, uint16(2480), info.Power)
+}
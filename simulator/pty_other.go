@@ -0,0 +1,19 @@
+//go:build !linux
+
+package simulator
+
+import (
+	"errors"
+	"os"
+)
+
+// PTY is a pseudo-terminal, only supported on Linux
+type PTY struct {
+	*os.File
+	Name string
+}
+
+// OpenPTY is only supported on Linux, use Inverter.Serve and a TCP connection instead
+func OpenPTY() (*PTY, error) {
+	return nil, errors.New("pseudo-terminals are only supported on Linux, listen on a TCP port instead")
+}
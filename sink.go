@@ -0,0 +1,247 @@
+package solaxx1rs485
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink persists poller samples, e.g. poller.Run(ctx, func(s Sample) { sink.Write(s) })
+type Sink interface {
+	Write(Sample) error // Failed samples (Err set) are skipped
+	Close() error
+}
+
+/*
+InfluxSink writes samples in the InfluxDB line protocol to a writer, e.g. stdout or a file.
+
+Every sample becomes a single line in Measurement, tagged with the address and
+the serial of the inverter. Numeric fields are written as floats, Mode and ErrMessage as strings.
+*/
+type InfluxSink struct {
+	Measurement string
+
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewInfluxSink returns a sink writing to w, w is not closed by Close
+func NewInfluxSink(w io.Writer) *InfluxSink {
+	return &InfluxSink{Measurement: "solax", w: w}
+}
+
+// NewInfluxFileSink returns a sink appending to the file at path
+func NewInfluxFileSink(path string) (*InfluxSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := NewInfluxSink(f)
+	s.closer = f
+	return s, nil
+}
+
+func (s *InfluxSink) Write(sample Sample) error {
+	if sample.Err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(s.w, InfluxLine(s.Measurement, sample))
+	return err
+}
+
+func (s *InfluxSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+/*
+InfluxHTTPSink posts samples to an InfluxDB write endpoint.
+
+URL is the complete write URL including the database or bucket, e.g.
+http://localhost:8086/write?db=solax (1.x) or
+http://localhost:8086/api/v2/write?org=home&bucket=solax (2.x).
+*/
+type InfluxHTTPSink struct {
+	URL         string
+	Token       string // Sent as "Authorization: Token <token>" when set
+	Measurement string
+	Client      *http.Client
+}
+
+func NewInfluxHTTPSink(url, token string) *InfluxHTTPSink {
+	return &InfluxHTTPSink{URL: url, Token: token, Measurement: "solax", Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *InfluxHTTPSink) Write(sample Sample) error {
+	if sample.Err != nil {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, strings.NewReader(InfluxLine(s.Measurement, sample)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.Token)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("writing to %s: %s: %s", s.URL, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func (s *InfluxHTTPSink) Close() error {
+	return nil
+}
+
+// InfluxLine returns sample as a single line (including newline) in the InfluxDB line protocol
+func InfluxLine(measurement string, sample Sample) string {
+	var b strings.Builder
+	b.WriteString(influxEscape(measurement, ", "))
+	fmt.Fprintf(&b, ",address=%d", sample.Inverter.Address)
+	if len(sample.Inverter.Serial) > 0 {
+		b.WriteString(",serial=" + strings.ToUpper(hex.EncodeToString(sample.Inverter.Serial)))
+	}
+	for i, f := range NormalizeInfoResponse(*sample.Info).Fields() {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+		b.WriteString(sep + influxEscape(f.Name, ",= ") + "=")
+		switch v := f.Value.(type) {
+		case string, []string:
+			b.WriteString(`"` + influxEscape(f.String(), `\"`) + `"`)
+		case float64:
+			b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			b.WriteString(f.String())
+		}
+	}
+	fmt.Fprintf(&b, " %d\n", sample.Time.UnixNano())
+	return b.String()
+}
+
+func influxEscape(s, chars string) string {
+	for _, c := range chars {
+		s = strings.ReplaceAll(s, string(c), `\`+string(c))
+	}
+	return s
+}
+
+/*
+CSVSink writes samples to CSV files in Dir, rotating files by sample time.
+
+The file name is the sample time formatted with Pattern (a time layout), so the
+default "solax-2006-01-02.csv" starts a new file every day. New files start
+with a header. The columns are Time, Address and Serial followed by the fields
+of NormalizedNormalInfoResponse in declaration order.
+*/
+type CSVSink struct {
+	Dir      string
+	Pattern  string
+	Location *time.Location // Time zone used for rotation and the Time column, defaults to local time
+
+	mu   sync.Mutex
+	name string
+	file *os.File
+	w    *csv.Writer
+}
+
+func NewCSVSink(dir string) *CSVSink {
+	return &CSVSink{Dir: dir, Pattern: "solax-2006-01-02.csv", Location: time.Local}
+}
+
+// CSVHeader returns the column names used by CSVSink
+func CSVHeader() []string {
+	header := []string{"Time", "Address", "Serial"}
+	for _, f := range (NormalizedNormalInfoResponse{}).Fields() {
+		header = append(header, f.Name)
+	}
+	return header
+}
+
+func (s *CSVSink) Write(sample Sample) error {
+	if sample.Err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := sample.Time.In(s.location())
+	if err := s.rotate(t.Format(s.Pattern)); err != nil {
+		return err
+	}
+	record := []string{t.Format(time.RFC3339), strconv.Itoa(int(sample.Inverter.Address)), strings.ToUpper(hex.EncodeToString(sample.Inverter.Serial))}
+	for _, f := range NormalizeInfoResponse(*sample.Info).Fields() {
+		record = append(record, f.String())
+	}
+	s.w.Write(record)
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *CSVSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file, s.w, s.name = nil, nil, ""
+	return err
+}
+
+// rotate makes sure the file with name is open, writing the header when it is new or empty
+func (s *CSVSink) rotate(name string) error {
+	if s.file != nil && name == s.name {
+		return nil
+	}
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.Dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.name, s.w = f, name, csv.NewWriter(f)
+	if info.Size() == 0 {
+		s.w.Write(CSVHeader())
+	}
+	return nil
+}
+
+func (s *CSVSink) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
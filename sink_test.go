@@ -0,0 +1,121 @@
+package solaxx1rs485
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testSample(at time.Time, power uint16) Sample {
+	return Sample{
+		Time:     at,
+		Inverter: Inverter{Serial: []byte{0xAB, 0x01}, Address: 10},
+		Info:     &NormalInfoResponse{Power: power, EnergyTotal: 123, Frequency: 5001, Mode: 4, ErrMessage: 1<<31 | 1<<30},
+	}
+}
+
+func TestInfluxLine(t *testing.T) {
+	line := InfluxLine("solax", testSample(time.Unix(1700000000, 0), 1500))
+	require.True(t, strings.HasPrefix(line, "solax,address=10,serial=AB01 Temperature=0,EnergyToday=0,"), line)
+	require.Contains(t, line, ",Frequency=50.01,Power=1500,EnergyTotal=12.3,")
+	require.Contains(t, line, `,Mode="Permanent Fault",`)
+	require.Contains(t, line, `,ErrMessage="TzProtectFault, MainsLostFault" 1700000000000000000`)
+	require.True(t, strings.HasSuffix(line, "\n"))
+
+	require.Equal(t, `a\ b\,c`, influxEscape("a b,c", ", "))
+	require.Equal(t, `say \"hi\"`, influxEscape(`say "hi"`, `\"`))
+}
+
+func TestInfluxSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := NewInfluxSink(buf)
+	require.NoError(t, s.Write(testSample(time.Unix(1700000000, 0), 1500)))
+	require.NoError(t, s.Write(Sample{Err: ErrNoInverter}))
+	require.NoError(t, s.Close())
+	require.Equal(t, 1, strings.Count(buf.String(), "\n"))
+
+	path := filepath.Join(t.TempDir(), "solax.lp")
+	f, err := NewInfluxFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, f.Write(testSample(time.Unix(1700000000, 0), 1500)))
+	require.NoError(t, f.Close())
+	body, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, buf.String(), string(body))
+}
+
+func TestInfluxHTTPSink(t *testing.T) {
+	var got, auth, query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got, auth, query = string(body), r.Header.Get("Authorization"), r.URL.RawQuery
+		if strings.Contains(query, "bucket=missing") {
+			http.Error(w, `{"message":"bucket not found"}`, http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := NewInfluxHTTPSink(server.URL+"/api/v2/write?org=home&bucket=solax", "token")
+	sample := testSample(time.Unix(1700000000, 0), 1500)
+	require.NoError(t, s.Write(sample))
+	require.Equal(t, InfluxLine("solax", sample), got)
+	require.Equal(t, "Token token", auth)
+	require.Equal(t, "org=home&bucket=solax", query)
+
+	s.URL = server.URL + "/api/v2/write?org=home&bucket=missing"
+	err := s.Write(sample)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "bucket not found")
+}
+
+func TestCSVSink(t *testing.T) {
+	dir := t.TempDir()
+	s := NewCSVSink(dir)
+	s.Location = time.UTC
+
+	day := time.Date(2022, 6, 1, 23, 59, 0, 0, time.UTC)
+	require.NoError(t, s.Write(testSample(day, 100)))
+	require.NoError(t, s.Write(Sample{Time: day, Err: ErrNoInverter}))
+	require.NoError(t, s.Write(testSample(day.Add(30*time.Second), 200)))
+	require.NoError(t, s.Write(testSample(day.Add(time.Minute), 300)))
+	require.NoError(t, s.Close())
+
+	// reopening an existing file appends without a second header
+	s = NewCSVSink(dir)
+	s.Location = time.UTC
+	require.NoError(t, s.Write(testSample(day.Add(2*time.Minute), 400)))
+	require.NoError(t, s.Close())
+
+	records := func(name string) [][]string {
+		f, err := os.Open(filepath.Join(dir, name))
+		require.NoError(t, err)
+		defer f.Close()
+		records, err := csv.NewReader(f).ReadAll()
+		require.NoError(t, err)
+		return records
+	}
+
+	first := records("solax-2022-06-01.csv")
+	require.Len(t, first, 3)
+	require.Equal(t, CSVHeader(), first[0])
+	require.Equal(t, []string{"Time", "Address", "Serial", "Temperature", "EnergyToday"}, first[0][:5])
+	require.Equal(t, []string{"2022-06-01T23:59:00Z", "10", "AB01"}, first[1][:3])
+	require.Equal(t, "100", first[1][12])
+	require.Equal(t, "TzProtectFault, MainsLostFault", first[1][len(first[1])-1])
+
+	second := records("solax-2022-06-02.csv")
+	require.Len(t, second, 3)
+	require.Equal(t, "300", second[1][12])
+	require.Equal(t, "400", second[2][12])
+}
@@ -0,0 +1,197 @@
+package solaxx1rs485
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DecodedFrame is a frame seen on the bus together with its interpretation
+type DecodedFrame struct {
+	Time        time.Time
+	Raw         []byte
+	Packet      *Packet // nil when the frame is invalid
+	Err         error   // Why the frame is invalid, e.g. a checksum mismatch
+	Description string  // See DescribePacket
+}
+
+// DecodeFrame parses raw and describes the packet
+func DecodeFrame(t time.Time, raw []byte) DecodedFrame {
+	d := DecodedFrame{Time: t, Raw: raw}
+	d.Packet, d.Err = ParsePacket(raw)
+	if d.Err != nil {
+		d.Packet = nil
+		d.Description = "Invalid frame: " + d.Err.Error()
+		return d
+	}
+	d.Description = DescribePacket(d.Packet)
+	return d
+}
+
+// String returns the time, direction, address and description of the frame, e.g. "12:00:00.000 > 10 Query info"
+func (d DecodedFrame) String() string {
+	prefix := d.Time.Local().Format("15:04:05.000")
+	switch {
+	case d.Packet == nil:
+		return prefix + " ?     " + d.Description
+	case isResponse(d.Packet):
+		return fmt.Sprintf("%s < %-3d %s", prefix, d.Packet.Source&0xFF, d.Description)
+	}
+	return fmt.Sprintf("%s > %-3d %s", prefix, d.Packet.Destination&0xFF, d.Description)
+}
+
+// isResponse reports whether p is sent by an inverter, response function codes have the high bit set
+func isResponse(p *Packet) bool {
+	return p.FunctionCode&0x80 != 0
+}
+
+var registerFunctions = map[byte]string{0x01: "Register", 0x02: "Remove", 0x03: "Reconnect removed", 0x04: "Reregister"}
+
+// DescribePacket returns a human-readable interpretation of the control code, function code and data of p,
+// e.g. "Register XB3002I1234567 at address 10" or "Config write PowerLimit: ACK"
+func DescribePacket(p *Packet) string {
+	body, err := p.Bytes()
+	if err != nil {
+		return err.Error()
+	}
+	code := fmt.Sprintf(" (0x%02X/0x%02X)", p.ControlCode, p.FunctionCode)
+	function := p.FunctionCode &^ 0x80
+
+	switch p.ControlCode {
+	case ControlCodeRegister:
+		switch {
+		case p.FunctionCode == 0x00:
+			return "Query unregistered inverters" + code
+		case p.FunctionCode == 0x80:
+			res, err := ParseUnregisteredInverterResponse(body)
+			if err != nil {
+				return describeError(err, code)
+			}
+			return fmt.Sprintf("Unregistered inverter %s%s", printable(res.Serial), code)
+		case registerFunctions[function] == "":
+		case !isResponse(p) && len(p.Data) > 0:
+			serial, address := p.Data[:len(p.Data)-1], p.Data[len(p.Data)-1]
+			return fmt.Sprintf("%s %s at address %d%s", registerFunctions[function], printable(serial), address, code)
+		case isResponse(p):
+			return registerFunctions[function] + ": " + describeAck(parseAckResponse(body, ControlCodeRegister, p.FunctionCode)) + code
+		}
+
+	case ControlCodeRead:
+		switch p.FunctionCode {
+		case 0x02:
+			return "Query info" + code
+		case 0x03:
+			return "Query specifications" + code
+		case 0x04:
+			return "Query config" + code
+		case 0x82:
+			info, err := ParseNormalInfoResponse(body)
+			if err != nil {
+				return describeError(err, code)
+			}
+			values := []string{}
+			for _, f := range NormalizeInfoResponse(info).Fields() {
+				if s := f.String(); s != "" {
+					values = append(values, f.Name+"="+s+f.Unit)
+				}
+			}
+			return "Info" + code + ": " + strings.Join(values, " ")
+		case 0x83:
+			info, err := ParseInverterInfoResponse(body)
+			if err != nil {
+				return describeError(err, code)
+			}
+			return fmt.Sprintf("Specifications%s: %s %s %sW firmware %s serial %s", code, strings.TrimSpace(info.FactoryName), strings.TrimSpace(info.ModuleName),
+				strings.TrimSpace(info.RatedPower), strings.TrimSpace(info.FirmwareVersion), strings.TrimSpace(info.SerialNumber))
+		case 0x84:
+			config, err := ParseConfigResponse(body)
+			if err != nil {
+				return describeError(err, code)
+			}
+			return fmt.Sprintf("Config%s: %+v", code, NormalizeConfigResponse(config))
+		}
+
+	case ControlCodeWrite:
+		param := ConfigParameter(function)
+		if _, err := ConfigParameterByName(param.String()); err != nil {
+			break
+		}
+		if isResponse(p) {
+			return "Config write " + param.String() + ": " + describeAck(ParseWriteConfigResponse(body, param)) + code
+		}
+		if len(p.Data) == 2 {
+			raw := uint16FromBytes([2]byte{p.Data[0], p.Data[1]})
+			return fmt.Sprintf("Config write %s = %s%s", param, strconv.FormatFloat(param.normalize(raw), 'f', -1, 64), code)
+		}
+
+	case ControlCodeExecute:
+		action := ExecuteAction(function)
+		if action < ExecutePowerOn || action > ExecuteSelftest {
+			break
+		}
+		if isResponse(p) {
+			return "Execute " + action.String() + ": " + describeAck(ParseExecuteResponse(body, action)) + code
+		}
+		return "Execute " + action.String() + code
+	}
+	return fmt.Sprintf("Unknown%s: % X", code, p.Data)
+}
+
+func describeAck(err error) string {
+	switch {
+	case err == nil:
+		return "ACK"
+	case errors.Is(err, ErrNOACK):
+		return "NOACK"
+	}
+	return err.Error()
+}
+
+func describeError(err error, code string) string {
+	return "Invalid response" + code + ": " + err.Error()
+}
+
+// printable returns b as text when it only holds printable ASCII (e.g. a serial), as hex otherwise
+func printable(b []byte) string {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7E {
+			return fmt.Sprintf("%X", b)
+		}
+	}
+	return string(b)
+}
+
+/*
+Sniffer reads the frames on a bus without transmitting, e.g. to see what a
+Pocket WiFi dongle or another logger sharing the bus asks the inverters.
+
+Frames are read with a FrameReader, so when a frame turns out to be invalid
+(e.g. a byte was lost, so the frame swallowed the start of the next one), it
+resynchronises on the next 0xAA55 in that frame.
+*/
+type Sniffer struct {
+	frames *FrameReader
+}
+
+func NewSniffer(r io.Reader) *Sniffer {
+	return &Sniffer{frames: NewFrameReader(r)}
+}
+
+// Next waits for the next frame until ctx is done. Invalid frames are returned with Err set.
+func (s *Sniffer) Next(ctx context.Context) (DecodedFrame, error) {
+	for {
+		// a frame is complete as soon as its length is in, the deadline only bounds the wait for an incomplete one
+		frame, err := s.frames.ReadFrameContext(ctx, time.Now().Add(time.Hour))
+		if err != nil && !errors.Is(err, ErrInvalidBody) {
+			return DecodedFrame{}, err
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		return DecodeFrame(time.Now(), frame), nil
+	}
+}
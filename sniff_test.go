@@ -0,0 +1,90 @@
+package solaxx1rs485
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribePacket(t *testing.T) {
+	describe := func(p *Packet) string {
+		body, err := p.Bytes()
+		require.NoError(t, err)
+		return DecodeFrame(time.Now(), body).Description
+	}
+	response := func(controlCode, functionCode byte, data []byte) *Packet {
+		p := DefaultPacket()
+		p.Source = 0x000A
+		p.ControlCode, p.FunctionCode, p.Data = controlCode, functionCode, data
+		return p
+	}
+
+	require.Equal(t, "Query unregistered inverters (0x10/0x00)", describe(UnregisteredInverterRequest()))
+	require.Equal(t, "Unregistered inverter XB3002I1234567 (0x10/0x80)", describe(response(ControlCodeRegister, 0x80, []byte("XB3002I1234567"))))
+	require.Equal(t, "Register XB3002I1234567 at address 10 (0x10/0x01)", describe(RegisterInverterRequest([]byte("XB3002I1234567"), 10)))
+	require.Equal(t, "Register: NOACK (0x10/0x81)", describe(response(ControlCodeRegister, 0x81, []byte{StatusNOACK})))
+	require.Equal(t, "Reconnect removed: ACK (0x10/0x83)", describe(response(ControlCodeRegister, 0x83, []byte{StatusACK})))
+	require.Equal(t, "Query info (0x11/0x02)", describe(NormalInfoRequest(10)))
+	require.Contains(t, describe(response(ControlCodeRead, 0x82, NormalInfoResponse{Power: 1500, Mode: ModeNormal}.Data())), "Info (0x11/0x82): Temperature=0°C ")
+	require.Contains(t, describe(response(ControlCodeRead, 0x82, NormalInfoResponse{Power: 1500, Mode: ModeNormal}.Data())), " Power=1500W ")
+	require.Equal(t, "Invalid response (0x11/0x82): Could not parse body into valid packet", describe(response(ControlCodeRead, 0x82, []byte{1, 2})))
+	require.Equal(t, "Specifications (0x11/0x83): SolaxPower X1-3.0-S-D 3000W firmware 1.20 serial XB3002I1234567",
+		describe(response(ControlCodeRead, 0x83, InverterInfoResponse{Phase: 1, RatedPower: "  3000", FirmwareVersion: "1.20 ", ModuleName: "X1-3.0-S-D", FactoryName: "SolaxPower", SerialNumber: "XB3002I1234567"}.Data())))
+	require.Contains(t, describe(response(ControlCodeRead, 0x84, ConfigResponse{PowerLimit: 70}.Data())), "PowerLimit:70")
+	require.Equal(t, "Config write VacMax = 253.5 (0x12/0x04)", describe(WriteConfigRequest(10, ConfigVacMax, 2535)))
+	require.Equal(t, "Config write PowerLimit: ACK (0x12/0x8E)", describe(response(ControlCodeWrite, 0x8E, []byte{StatusACK})))
+	require.Equal(t, "Execute Restart (0x13/0x03)", describe(ExecuteRequest(10, ExecuteRestart)))
+	require.Equal(t, "Execute Selftest: NOACK (0x13/0x84)", describe(response(ControlCodeExecute, 0x84, []byte{StatusNOACK})))
+	require.Equal(t, "Unknown (0x14/0x01): 01 02", describe(response(0x14, 0x01, []byte{1, 2})))
+}
+
+func TestSniffer(t *testing.T) {
+	frame := func(p *Packet) []byte {
+		body, err := p.Bytes()
+		require.NoError(t, err)
+		return body
+	}
+	query := frame(NormalInfoRequest(10))
+	resp := DefaultPacket()
+	resp.Source, resp.ControlCode, resp.FunctionCode, resp.Data = 10, ControlCodeRead, 0x82, NormalInfoResponse{Power: 1500}.Data()
+	answer := frame(resp)
+
+	// noise, a query, an answer that lost a byte directly followed by another query, and an answer
+	stream := []byte{0x00, 0xFF}
+	stream = append(stream, query...)
+	stream = append(stream, answer[:20]...)
+	stream = append(stream, answer[21:]...)
+	stream = append(stream, query...)
+	stream = append(stream, answer...)
+	s := NewSniffer(bytes.NewReader(stream))
+
+	next := func() DecodedFrame {
+		f, err := s.Next(context.Background())
+		require.NoError(t, err)
+		return f
+	}
+	f := next()
+	require.NoError(t, f.Err)
+	require.Equal(t, query, f.Raw)
+	require.Contains(t, f.String(), " > 10  Query info (0x11/0x02)")
+
+	f = next()
+	require.ErrorIs(t, f.Err, ErrInvalidBody)
+	require.Nil(t, f.Packet)
+	require.Contains(t, f.String(), " ?     Invalid frame: ")
+
+	// resynchronised on the next query
+	require.Equal(t, query, next().Raw)
+	f = next()
+	require.NoError(t, f.Err)
+	require.Equal(t, byte(0x82), f.Packet.FunctionCode)
+	require.Contains(t, f.String(), " < 10  Info (0x11/0x82): ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := s.Next(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
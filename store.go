@@ -0,0 +1,321 @@
+package solaxx1rs485
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidStore = errors.New("Not a sample store")
+	ErrUnknownField = errors.New("Unknown or non-numeric field")
+)
+
+const (
+	storeMagic    = "SOLAXST1"
+	maxRecordSize = 64 << 10 // far above an actual record, bounds the allocation for a damaged length
+)
+
+/*
+Store is an append-only file of successful samples, for sites without a time-series database.
+
+The file starts with the magic "SOLAXST1", followed by one record per sample:
+
+	Length		4	Length of the payload
+	Checksum	4	CRC-32 (IEEE) of the payload
+	Payload:
+	Time		8	Unix time in nanoseconds
+	Address		1
+	Serial len	1
+	Serial		N
+	Info		50	NormalInfoResponse in the 0x82 data layout (see NormalInfoResponse.Data)
+	Raw		N	Raw response frame, until the end of the payload
+
+All integers are big-endian. A record at the end of the file that was cut short
+or fails the checksum (e.g. after a crash while writing) ends the store when
+reading, OpenStore truncates the file to the last complete record. Damaged
+records before that, or records longer than 64 KiB, make the store invalid.
+*/
+type Store struct {
+	Path string
+
+	mu       sync.Mutex
+	file     *os.File
+	readOnly bool
+}
+
+// Record is a single sample read from a Store
+type Record struct {
+	Time     time.Time
+	Inverter Inverter
+	Info     NormalInfoResponse
+	Raw      []byte
+}
+
+// OpenStore opens or creates the store at path
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{Path: path, file: f}
+
+	// validate the file and continue after the last complete record
+	end, err := s.scan(func(Record) error { return nil })
+	if err == nil && end == 0 {
+		_, err = f.Write([]byte(storeMagic))
+		end = int64(len(storeMagic))
+	}
+	if err == nil {
+		err = f.Truncate(end)
+	}
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenStoreReadOnly opens the store at path for queries only, e.g. while another process writes to it
+func OpenStoreReadOnly(path string) (*Store, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{Path: path, file: f, readOnly: true}
+	if _, err := s.scan(func(Record) error { return nil }); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write appends a sample to the store, failed samples are skipped. Store implements Sink.
+func (s *Store) Write(sample Sample) error {
+	if sample.Err != nil {
+		return nil
+	}
+	payload := make([]byte, 10, 10+len(sample.Inverter.Serial)+50+len(sample.Raw))
+	binary.BigEndian.PutUint64(payload, uint64(sample.Time.UnixNano()))
+	payload[8] = sample.Inverter.Address
+	payload[9] = byte(len(sample.Inverter.Serial))
+	payload = append(payload, sample.Inverter.Serial...)
+	payload = append(payload, sample.Info.Data()...)
+	payload = append(payload, sample.Raw...)
+
+	record := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if s.readOnly {
+		return fmt.Errorf("%s is opened read-only", s.Path)
+	}
+	_, err := s.file.Write(record)
+	return err
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Query calls fn for every record with from <= Time < to, in the order they were written.
+// A zero from or to is unbounded.
+func (s *Store) Query(from, to time.Time, fn func(Record) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	_, err := s.scan(func(r Record) error {
+		if (!from.IsZero() && r.Time.Before(from)) || (!to.IsZero() && !r.Time.Before(to)) {
+			return nil
+		}
+		return fn(r)
+	})
+	return err
+}
+
+// Records returns all records with from <= Time < to, see Query
+func (s *Store) Records(from, to time.Time) ([]Record, error) {
+	records := []Record{}
+	err := s.Query(from, to, func(r Record) error {
+		records = append(records, r)
+		return nil
+	})
+	return records, err
+}
+
+// scan reads all complete records and returns the offset after the last one, 0 for an empty file.
+// It must be called with mu held (or before the store is shared).
+func (s *Store) scan(fn func(Record) error) (int64, error) {
+	end, err := s.file.Seek(0, io.SeekEnd)
+	if err != nil || end == 0 {
+		return 0, err
+	}
+	// the file offset is restored for Write, which appends at the end
+	defer s.file.Seek(end, io.SeekStart)
+
+	r := bufio.NewReader(io.NewSectionReader(s.file, 0, end))
+	magic := make([]byte, len(storeMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != storeMagic {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidStore, s.Path)
+	}
+	offset := int64(len(storeMagic))
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return offset, nil
+		}
+		length := binary.BigEndian.Uint32(header)
+		if length > maxRecordSize {
+			return offset, fmt.Errorf("%w: record of %d bytes at offset %d in %s", ErrInvalidStore, length, offset, s.Path)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, nil
+		}
+		// a damaged last record is the result of a write that didn't complete
+		last := offset+int64(len(header)+len(payload)) == end
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			if last {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("%w: checksum mismatch at offset %d in %s", ErrInvalidStore, offset, s.Path)
+		}
+		record, err := parseRecord(payload)
+		if err != nil {
+			if last {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("%w: offset %d in %s", err, offset, s.Path)
+		}
+		if err := fn(record); err != nil {
+			return offset, err
+		}
+		offset += int64(len(header) + len(payload))
+	}
+}
+
+func parseRecord(payload []byte) (Record, error) {
+	if len(payload) < 10 || len(payload) < 10+int(payload[9])+50 {
+		return Record{}, fmt.Errorf("%w: record too short", ErrInvalidStore)
+	}
+	serialEnd := 10 + int(payload[9])
+	info, err := NormalInfoResponseFromData(payload[serialEnd : serialEnd+50])
+	if err != nil {
+		return Record{}, err
+	}
+	r := Record{
+		Time:     time.Unix(0, int64(binary.BigEndian.Uint64(payload))),
+		Inverter: Inverter{Address: payload[8]},
+		Info:     info,
+		Raw:      payload[serialEnd+50:],
+	}
+	if serialEnd > 10 {
+		r.Inverter.Serial = payload[10:serialEnd]
+	}
+	return r, nil
+}
+
+// Aggregate summarizes a field of all records of an inverter in a time bucket
+type Aggregate struct {
+	Start   time.Time
+	Address byte
+	Count   int
+	Min     float64
+	Avg     float64
+	Max     float64
+}
+
+// AggregateRecords groups records per inverter in buckets of resolution and returns the min, avg and max of field
+// (a numeric field of NormalizedNormalInfoResponse, e.g. Power), ordered by bucket and address.
+// Buckets are aligned to multiples of resolution since the zero time (UTC).
+func AggregateRecords(records []Record, field string, resolution time.Duration) ([]Aggregate, error) {
+	if resolution <= 0 {
+		return nil, fmt.Errorf("resolution must be positive, got %s", resolution)
+	}
+	if _, err := fieldValue(NormalizedNormalInfoResponse{}, field); err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		start   int64
+		address byte
+	}
+	buckets := map[key]*Aggregate{}
+	sums := map[key]float64{}
+	for _, r := range records {
+		v, _ := fieldValue(NormalizeInfoResponse(r.Info), field)
+		start := r.Time.Truncate(resolution)
+		k := key{start.UnixNano(), r.Inverter.Address}
+		a, ok := buckets[k]
+		if !ok {
+			a = &Aggregate{Start: start, Address: r.Inverter.Address, Min: math.Inf(1), Max: math.Inf(-1)}
+			buckets[k] = a
+		}
+		a.Count++
+		a.Min = math.Min(a.Min, v)
+		a.Max = math.Max(a.Max, v)
+		sums[k] += v
+	}
+
+	result := []Aggregate{}
+	for k, a := range buckets {
+		a.Avg = sums[k] / float64(a.Count)
+		result = append(result, *a)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Start.Equal(result[j].Start) {
+			return result[i].Start.Before(result[j].Start)
+		}
+		return result[i].Address < result[j].Address
+	})
+	return result, nil
+}
+
+// fieldValue returns the numeric field with name (case-insensitive) from n
+func fieldValue(n NormalizedNormalInfoResponse, name string) (float64, error) {
+	for _, f := range n.Fields() {
+		if !strings.EqualFold(f.Name, name) {
+			continue
+		}
+		switch v := f.Value.(type) {
+		case uint16:
+			return float64(v), nil
+		case uint32:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownField, name)
+}
@@ -0,0 +1,178 @@
+package solaxx1rs485
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func storeSample(t *testing.T, at time.Time, address byte, power uint16) Sample {
+	info := NormalInfoResponse{Power: power, EnergyTotal: 1000, Mode: 2}
+	p := DefaultPacket()
+	p.Source = uint16(address)
+	p.ControlCode = ControlCodeRead
+	p.FunctionCode = 0x82
+	p.Data = info.Data()
+	raw, err := p.Bytes()
+	require.NoError(t, err)
+	return Sample{Time: at, Inverter: Inverter{Serial: []byte("SN1"), Address: address}, Info: &info, Raw: raw}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "solax", "samples.db")
+	s, err := OpenStore(path)
+	require.NoError(t, err)
+
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		require.NoError(t, s.Write(storeSample(t, start.Add(time.Duration(i)*5*time.Minute), 1, uint16(100*i))))
+	}
+	require.NoError(t, s.Write(Sample{Time: start, Err: ErrNoInverter}))
+	require.NoError(t, s.Close())
+
+	// reopening appends to the existing records
+	s, err = OpenStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Write(storeSample(t, start.Add(30*time.Minute), 2, 50)))
+
+	records, err := s.Records(time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 7)
+	require.True(t, start.Equal(records[0].Time))
+	require.Equal(t, Inverter{Serial: []byte("SN1"), Address: 1}, records[0].Inverter)
+	require.Equal(t, uint16(500), records[5].Info.Power)
+	parsed, err := ParseNormalInfoResponse(records[5].Raw)
+	require.NoError(t, err)
+	require.Equal(t, records[5].Info, parsed)
+
+	records, err = s.Records(start.Add(5*time.Minute), start.Add(15*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, uint16(100), records[0].Info.Power)
+	require.Equal(t, uint16(200), records[1].Info.Power)
+
+	r, err := OpenStoreReadOnly(path)
+	require.NoError(t, err)
+	defer r.Close()
+	records, err = r.Records(time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 7)
+	require.Error(t, r.Write(storeSample(t, start, 1, 100)))
+}
+
+func TestStoreTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.db")
+	s, err := OpenStore(path)
+	require.NoError(t, err)
+	at := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.Write(storeSample(t, at, 1, 100)))
+	require.NoError(t, s.Write(storeSample(t, at.Add(time.Minute), 1, 200)))
+	require.NoError(t, s.Close())
+
+	// simulate a crash halfway through writing the last record
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-10))
+
+	s, err = OpenStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+	records, err := s.Records(time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	require.NoError(t, s.Write(storeSample(t, at.Add(2*time.Minute), 1, 300)))
+	records, err = s.Records(time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, uint16(300), records[1].Info.Power)
+}
+
+func TestStoreDamagedRecord(t *testing.T) {
+	at := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	// writeStore writes two records and returns the size of the file
+	writeStore := func(t *testing.T, path string) int64 {
+		s, err := OpenStore(path)
+		require.NoError(t, err)
+		require.NoError(t, s.Write(storeSample(t, at, 1, 100)))
+		require.NoError(t, s.Write(storeSample(t, at.Add(time.Minute), 1, 200)))
+		require.NoError(t, s.Close())
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		return info.Size()
+	}
+	// damage overwrites the bytes at offset
+	damage := func(t *testing.T, path string, offset int64, b []byte) {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		require.NoError(t, err)
+		defer f.Close()
+		_, err = f.WriteAt(b, offset)
+		require.NoError(t, err)
+	}
+
+	t.Run("Checksum mismatch in the last record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "samples.db")
+		size := writeStore(t, path)
+		damage(t, path, size-1, []byte{0xFF})
+
+		s, err := OpenStore(path)
+		require.NoError(t, err)
+		defer s.Close()
+		records, err := s.Records(time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Less(t, info.Size(), size)
+	})
+
+	t.Run("Checksum mismatch before the last record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "samples.db")
+		writeStore(t, path)
+		damage(t, path, int64(len(storeMagic))+20, []byte{0xFF})
+		_, err := OpenStore(path)
+		require.ErrorIs(t, err, ErrInvalidStore)
+	})
+
+	t.Run("Length above the maximum", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "samples.db")
+		writeStore(t, path)
+		damage(t, path, int64(len(storeMagic)), []byte{0xFF, 0xFF, 0xFF, 0xFF})
+		_, err := OpenStore(path)
+		require.ErrorIs(t, err, ErrInvalidStore)
+	})
+}
+
+func TestStoreInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	require.NoError(t, os.WriteFile(path, []byte("[]\n"), 0o644))
+	_, err := OpenStore(path)
+	require.True(t, errors.Is(err, ErrInvalidStore))
+}
+
+func TestAggregateRecords(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	records := []Record{}
+	for i, power := range []uint16{100, 200, 600, 50} {
+		records = append(records, Record{Time: start.Add(time.Duration(i) * 5 * time.Minute), Inverter: Inverter{Address: 1}, Info: NormalInfoResponse{Power: power}})
+	}
+	records = append(records, Record{Time: start, Inverter: Inverter{Address: 2}, Info: NormalInfoResponse{Power: 10}})
+
+	aggregates, err := AggregateRecords(records, "power", 15*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []Aggregate{
+		{Start: start, Address: 1, Count: 3, Min: 100, Avg: 300, Max: 600},
+		{Start: start, Address: 2, Count: 1, Min: 10, Avg: 10, Max: 10},
+		{Start: start.Add(15 * time.Minute), Address: 1, Count: 1, Min: 50, Avg: 50, Max: 50},
+	}, aggregates)
+
+	_, err = AggregateRecords(records, "Mode", 15*time.Minute)
+	require.True(t, errors.Is(err, ErrUnknownField))
+	_, err = AggregateRecords(records, "Power", 0)
+	require.Error(t, err)
+}
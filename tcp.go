@@ -0,0 +1,157 @@
+package solaxx1rs485
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+TCPConnection is a Connection to a transparent RS485-to-Ethernet gateway
+(e.g. USR-TCP232 or Elfin EW11). The gateway forwards all bytes as-is, so the
+regular packet format is used on the socket.
+
+A read that times out returns io.EOF, like the serial port does. A broken
+socket is closed and dialed again on the next Read, Write or Flush.
+*/
+type TCPConnection struct {
+	Addr         string
+	DialTimeout  time.Duration // Timeout for (re)connecting to the gateway
+	ReadTimeout  time.Duration // Bounds a single read, the Client keeps reading until WaitTime passed
+	FlushTimeout time.Duration // Time without incoming bytes after which Flush considers the socket drained
+	MaxFlushTime time.Duration // Upper limit for a Flush, e.g. when another device keeps talking on the bus, defaults to 250ms
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+const defaultMaxFlushTime = 250 * time.Millisecond
+
+// DialTCP connects to the gateway at addr (host:port)
+func DialTCP(addr string) (*TCPConnection, error) {
+	t := &TCPConnection{
+		Addr:         addr,
+		DialTimeout:  5 * time.Second,
+		ReadTimeout:  100 * time.Millisecond,
+		FlushTimeout: 10 * time.Millisecond,
+		MaxFlushTime: defaultMaxFlushTime,
+	}
+	if _, err := t.connection(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewTCPClient returns a Client for the gateway at addr (host:port)
+func NewTCPClient(addr string) (*Client, error) {
+	conn, err := DialTCP(addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithConnection(conn)
+}
+
+func (t *TCPConnection) Read(p []byte) (int, error) {
+	conn, err := t.connection()
+	if err != nil {
+		return 0, err
+	}
+	conn.SetReadDeadline(time.Now().Add(t.ReadTimeout))
+	n, err := conn.Read(p)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return n, io.EOF
+		}
+		t.reset(conn)
+	}
+	return n, err
+}
+
+func (t *TCPConnection) Write(p []byte) (int, error) {
+	conn, err := t.connection()
+	if err != nil {
+		return 0, err
+	}
+	n, err := conn.Write(p)
+	if err != nil && n == 0 {
+		// The socket may have been closed by the gateway since the last call, try once more on a new connection
+		t.reset(conn)
+		if conn, err = t.connection(); err != nil {
+			return 0, err
+		}
+		n, err = conn.Write(p)
+	}
+	if err != nil {
+		t.reset(conn)
+	}
+	return n, err
+}
+
+// Flush drains all bytes that are pending on the socket, for at most MaxFlushTime
+func (t *TCPConnection) Flush() error {
+	conn, err := t.connection()
+	if err != nil {
+		return err
+	}
+	maxFlushTime := t.MaxFlushTime
+	if maxFlushTime <= 0 {
+		maxFlushTime = defaultMaxFlushTime
+	}
+	end := time.Now().Add(maxFlushTime)
+	buf := make([]byte, 256)
+	for {
+		deadline := time.Now().Add(t.FlushTimeout)
+		if deadline.After(end) {
+			deadline = end
+		}
+		conn.SetReadDeadline(deadline)
+		_, err := conn.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) || (err == nil && !time.Now().Before(end)) {
+			return nil
+		}
+		if err != nil {
+			t.reset(conn)
+			_, err = t.connection()
+			return err
+		}
+	}
+}
+
+func (t *TCPConnection) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+// connection returns the current socket, dialing the gateway when there is none
+func (t *TCPConnection) connection() (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		return t.conn, nil
+	}
+	conn, err := net.DialTimeout("tcp", t.Addr, t.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	t.conn = conn
+	return conn, nil
+}
+
+// reset closes conn when it is still the current socket
+func (t *TCPConnection) reset(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == conn {
+		t.conn.Close()
+		t.conn = nil
+	}
+}
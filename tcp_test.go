@@ -0,0 +1,132 @@
+package solaxx1rs485
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// gateway accepts connections on a local listener and answers every request on a connection
+// with handle. Connections are closed after closeAfter requests (0: never).
+func gateway(t *testing.T, closeAfter int, greeting []byte, handle func(req []byte) []byte) (string, <-chan struct{}) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	accepted := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				conn.Write(greeting)
+				f := NewFrameReader(conn)
+				for n := 1; ; n++ {
+					req, err := f.ReadFrame(time.Now().Add(time.Second))
+					if err != nil || len(req) == 0 {
+						return
+					}
+					conn.Write(handle(req))
+					if n == closeAfter {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return l.Addr().String(), accepted
+}
+
+// infoResponder answers with the address as power. It runs on the gateway goroutine, so
+// errors are reported with t.Error (not require) and the request is left unanswered.
+func infoResponder(t *testing.T) func(req []byte) []byte {
+	return func(req []byte) []byte {
+		p, err := ParsePacket(req)
+		if err != nil {
+			t.Errorf("gateway: invalid request %X: %s", req, err)
+			return nil
+		}
+		resp := DefaultPacket()
+		resp.Source = p.Destination
+		resp.ControlCode = ControlCodeRead
+		resp.FunctionCode = 0x82
+		resp.Data = make([]byte, 50)
+		resp.Data[19] = byte(p.Destination)
+		body, err := resp.Bytes()
+		if err != nil {
+			t.Errorf("gateway: %s", err)
+		}
+		return body
+	}
+}
+
+func TestTCPClient(t *testing.T) {
+	t.Run("Flush drains pending bytes", func(t *testing.T) {
+		addr, _ := gateway(t, 0, []byte{0xAA, 0x55, 0x01, 0x02, 0x03}, infoResponder(t))
+		c, err := NewTCPClient(addr)
+		require.NoError(t, err)
+		defer c.Conn.Close()
+
+		time.Sleep(20 * time.Millisecond) // let the greeting arrive
+		info, err := c.GetInfo(&Inverter{Address: 0x05})
+		require.NoError(t, err)
+		require.Equal(t, uint16(5), info.Power)
+	})
+
+	t.Run("Reconnects when the gateway closes the socket", func(t *testing.T) {
+		addr, accepted := gateway(t, 1, nil, infoResponder(t))
+		c, err := NewTCPClient(addr)
+		require.NoError(t, err)
+		defer c.Conn.Close()
+
+		for i := 1; i <= 3; i++ {
+			info, err := c.GetInfo(&Inverter{Address: byte(i)})
+			require.NoError(t, err)
+			require.Equal(t, uint16(i), info.Power)
+			time.Sleep(20 * time.Millisecond) // let the gateway close the socket
+		}
+		require.Len(t, accepted, 3)
+	})
+
+	t.Run("Flush on a gateway that keeps sending", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for {
+				if _, err := conn.Write([]byte{0x00}); err != nil {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+
+		conn, err := DialTCP(l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.MaxFlushTime = 50 * time.Millisecond
+		start := time.Now()
+		require.NoError(t, conn.Flush())
+		require.Less(t, time.Since(start), 200*time.Millisecond)
+	})
+
+	t.Run("Dial error", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		l.Close()
+		_, err = NewTCPClient(addr)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,247 @@
+package solaxx1rs485
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Modes reported by GetInfo, see ModeName
+const (
+	ModeNormal         uint16 = 2 // Exporting power without faults
+	ModeFault          uint16 = 3
+	ModePermanentFault uint16 = 4
+)
+
+// FaultEpisode is a period during which a fault bit was set, or the inverter was in a tracked mode (see FaultTracker.Modes)
+type FaultEpisode struct {
+	Address byte
+	Fault   FaultCode `json:",omitempty"` // Single fault bit, 0 for mode episodes
+	Mode    *uint16   `json:",omitempty"` // Mode for mode episodes
+	Start   time.Time // Time of the first sample with the fault or mode
+	End     time.Time // Time of the first sample without it, zero while ongoing
+}
+
+// Name returns the fault name, or the mode name for mode episodes
+func (e FaultEpisode) Name() string {
+	if e.Mode != nil {
+		return "Mode " + ModeName(*e.Mode)
+	}
+	return e.Fault.String()
+}
+
+func (e FaultEpisode) Ongoing() bool {
+	return e.End.IsZero()
+}
+
+// Duration returns the length of the episode, ongoing episodes are measured until now
+func (e FaultEpisode) Duration(now time.Time) time.Duration {
+	if e.Ongoing() {
+		return now.Sub(e.Start)
+	}
+	return e.End.Sub(e.Start)
+}
+
+// FaultEvent is emitted when a fault bit sets or clears, or the mode changes
+type FaultEvent struct {
+	Time    time.Time
+	Cleared bool         // false when the fault was set or the mode entered
+	Episode FaultEpisode // End is set for cleared events
+}
+
+func (e FaultEvent) String() string {
+	if e.Cleared {
+		return fmt.Sprintf("inverter %d: %s cleared after %s", e.Episode.Address, e.Episode.Name(), e.Episode.Duration(e.Time).Round(time.Second))
+	}
+	return fmt.Sprintf("inverter %d: %s", e.Episode.Address, e.Episode.Name())
+}
+
+/*
+FaultTracker turns successive samples into fault events and episodes.
+
+Every fault bit of ErrMessage and every mode in Modes becomes an episode from
+the first sample it was seen until the first sample it was gone. By default only
+the fault modes are tracked: every inverter passes Wait and Check at dusk and
+dawn, which would fill the history with episodes of a healthy site.
+The episodes are persisted as JSON in Path, so the history survives restarts;
+episodes that were ongoing when the tracker stopped continue with the next sample.
+The file is replaced as a whole on every change, so a crash leaves either the old
+or the new history. It holds the history of a single tracker: two processes
+saving to the same Path overwrite each other's episodes.
+*/
+type FaultTracker struct {
+	Path        string   // Location of the history, not persisted when empty
+	MaxEpisodes int      // Number of finished episodes kept, the oldest are dropped first
+	Modes       []uint16 // Modes tracked as episodes
+
+	mu       sync.Mutex
+	episodes []FaultEpisode
+}
+
+// NewFaultTracker returns a tracker keeping 1000 episodes of the faults and the Fault and Permanent Fault modes,
+// loading the history from path when the file exists
+func NewFaultTracker(path string) (*FaultTracker, error) {
+	t := &FaultTracker{Path: path, MaxEpisodes: 1000, Modes: []uint16{ModeFault, ModePermanentFault}}
+	if path == "" {
+		return t, nil
+	}
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &t.episodes); err != nil {
+		return nil, fmt.Errorf("reading fault history %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// Track updates the episodes with a sample and returns the resulting events. Failed samples are ignored.
+// The history is saved when anything changed.
+func (t *FaultTracker) Track(s Sample) ([]FaultEvent, error) {
+	if s.Err != nil {
+		return nil, nil
+	}
+	events := t.Update(s.Inverter.Address, s.Time, *s.Info)
+	if len(events) == 0 {
+		return nil, nil
+	}
+	return events, t.Save()
+}
+
+// Update updates the episodes of the inverter at address with info, sampled at time at, and returns the resulting events
+func (t *FaultTracker) Update(address byte, at time.Time, info NormalInfoResponse) []FaultEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	events := []FaultEvent{}
+	ongoing := map[FaultCode]bool{}
+	var ongoingMode *uint16
+	for i := range t.episodes {
+		e := &t.episodes[i]
+		if e.Address != address || !e.Ongoing() {
+			continue
+		}
+		stillSet := info.Faults().Has(e.Fault)
+		if e.Mode != nil {
+			stillSet = *e.Mode == info.Mode
+		}
+		if stillSet {
+			if e.Mode != nil {
+				ongoingMode = e.Mode
+			} else {
+				ongoing[e.Fault] = true
+			}
+			continue
+		}
+		e.End = at
+		events = append(events, FaultEvent{Time: at, Cleared: true, Episode: *e})
+	}
+
+	for _, f := range info.Faults().List() {
+		if !ongoing[f] {
+			e := FaultEpisode{Address: address, Fault: f, Start: at}
+			t.episodes = append(t.episodes, e)
+			events = append(events, FaultEvent{Time: at, Episode: e})
+		}
+	}
+	if t.tracks(info.Mode) && ongoingMode == nil {
+		mode := info.Mode
+		e := FaultEpisode{Address: address, Mode: &mode, Start: at}
+		t.episodes = append(t.episodes, e)
+		events = append(events, FaultEvent{Time: at, Episode: e})
+	}
+	t.prune()
+	return events
+}
+
+// tracks reports whether mode is one of the Modes. It must be called with mu held.
+func (t *FaultTracker) tracks(mode uint16) bool {
+	for _, m := range t.Modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Episodes returns all episodes that were ongoing at or after since, ordered by start
+func (t *FaultTracker) Episodes(since time.Time) []FaultEpisode {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	episodes := []FaultEpisode{}
+	for _, e := range t.episodes {
+		if !e.Start.Before(since) || e.Ongoing() || !e.End.Before(since) {
+			episodes = append(episodes, e)
+		}
+	}
+	sort.SliceStable(episodes, func(i, j int) bool { return episodes[i].Start.Before(episodes[j].Start) })
+	return episodes
+}
+
+// Save writes the history to a temporary file and renames it to Path
+func (t *FaultTracker) Save() error {
+	if t.Path == "" {
+		return nil
+	}
+	t.mu.Lock()
+	body, err := json.MarshalIndent(t.episodes, "", "  ")
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.Path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(t.Path), filepath.Base(t.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(body)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), t.Path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// prune drops the oldest finished episodes beyond MaxEpisodes. It must be called with mu held.
+func (t *FaultTracker) prune() {
+	finished := 0
+	for _, e := range t.episodes {
+		if !e.Ongoing() {
+			finished++
+		}
+	}
+	if t.MaxEpisodes <= 0 || finished <= t.MaxEpisodes {
+		return
+	}
+	drop := finished - t.MaxEpisodes
+	kept := []FaultEpisode{}
+	for _, e := range t.episodes {
+		if drop > 0 && !e.Ongoing() {
+			drop--
+			continue
+		}
+		kept = append(kept, e)
+	}
+	t.episodes = kept
+}
@@ -0,0 +1,123 @@
+package solaxx1rs485
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faults.json")
+	tracker, err := NewFaultTracker(path)
+	require.NoError(t, err)
+
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	track := func(minutes int, address byte, mode uint16, faults FaultCode) []FaultEvent {
+		events, err := tracker.Track(Sample{Time: at(minutes), Inverter: Inverter{Address: address}, Info: &NormalInfoResponse{Mode: mode, ErrMessage: uint32(faults)}})
+		require.NoError(t, err)
+		return events
+	}
+
+	require.Empty(t, track(0, 1, ModeNormal, 0))
+
+	events := track(1, 1, 3, FaultGridVolt|FaultGridFreq)
+	require.Len(t, events, 3)
+	require.False(t, events[0].Cleared)
+	require.Equal(t, FaultGridVolt, events[0].Episode.Fault)
+	require.Equal(t, FaultGridFreq, events[1].Episode.Fault)
+	require.Equal(t, "Mode Fault", events[2].Episode.Name())
+	require.Equal(t, "inverter 1: GridVoltFault", events[0].String())
+
+	// unchanged, and another inverter doesn't affect inverter 1
+	require.Empty(t, track(2, 1, 3, FaultGridVolt|FaultGridFreq))
+	require.Len(t, track(2, 2, ModeNormal, FaultFan), 1)
+
+	events = track(5, 1, 3, FaultGridVolt)
+	require.Len(t, events, 1)
+	require.True(t, events[0].Cleared)
+	require.Equal(t, FaultGridFreq, events[0].Episode.Fault)
+	require.Equal(t, 4*time.Minute, events[0].Episode.Duration(at(100)))
+	require.Equal(t, "inverter 1: GridFreqFault cleared after 4m0s", events[0].String())
+
+	// the history survives a restart, ongoing episodes continue
+	tracker, err = NewFaultTracker(path)
+	require.NoError(t, err)
+	events = track(10, 1, ModeNormal, 0)
+	require.Len(t, events, 2)
+	require.Equal(t, FaultGridVolt, events[0].Episode.Fault)
+	require.Equal(t, at(1), events[0].Episode.Start)
+	require.Equal(t, at(10), events[0].Episode.End)
+	require.NotNil(t, events[1].Episode.Mode)
+	require.Equal(t, uint16(3), *events[1].Episode.Mode)
+
+	episodes := tracker.Episodes(time.Time{})
+	require.Len(t, episodes, 4)
+	require.True(t, episodes[3].Ongoing())
+	require.Equal(t, FaultFan, episodes[3].Fault)
+	require.Equal(t, time.Hour, episodes[3].Duration(at(62)))
+
+	// episodes that ended before since are left out
+	require.Len(t, tracker.Episodes(at(7)), 3)
+
+	// the history is replaced through a temporary file, which doesn't stay behind
+	files, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// failed samples are ignored
+	events, err = tracker.Track(Sample{Time: at(11), Inverter: Inverter{Address: 1}, Err: ErrNoInverter})
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
+
+func TestFaultTrackerDayNight(t *testing.T) {
+	tracker, err := NewFaultTracker("")
+	require.NoError(t, err)
+	dusk := time.Date(2022, 6, 1, 21, 30, 0, 0, time.UTC)
+	day := []struct {
+		at   time.Duration
+		mode uint16
+	}{
+		{0, ModeNormal},
+		{30 * time.Minute, 0},                     // Wait at dusk
+		{9 * time.Hour, 1},                        // Check at dawn
+		{9*time.Hour + 5*time.Minute, ModeNormal}, // exporting again
+	}
+
+	// a healthy inverter going through the night has no episodes
+	for _, s := range day {
+		require.Empty(t, tracker.Update(1, dusk.Add(s.at), NormalInfoResponse{Mode: s.mode}))
+	}
+	require.Empty(t, tracker.Episodes(time.Time{}))
+
+	// unless the modes are tracked
+	tracker.Modes = append(tracker.Modes, 0)
+	for _, s := range day {
+		tracker.Update(1, dusk.Add(24*time.Hour+s.at), NormalInfoResponse{Mode: s.mode})
+	}
+	episodes := tracker.Episodes(time.Time{})
+	require.Len(t, episodes, 1)
+	require.Equal(t, "Mode Wait", episodes[0].Name())
+	require.Equal(t, 8*time.Hour+30*time.Minute, episodes[0].Duration(time.Time{}))
+}
+
+func TestFaultTrackerMaxEpisodes(t *testing.T) {
+	tracker, err := NewFaultTracker("")
+	require.NoError(t, err)
+	tracker.MaxEpisodes = 2
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		tracker.Update(1, start.Add(time.Duration(2*i)*time.Minute), NormalInfoResponse{Mode: ModeNormal, ErrMessage: uint32(FaultFan)})
+		tracker.Update(1, start.Add(time.Duration(2*i+1)*time.Minute), NormalInfoResponse{Mode: ModeNormal})
+	}
+	tracker.Update(1, start.Add(time.Hour), NormalInfoResponse{Mode: ModeNormal, ErrMessage: uint32(FaultFan)})
+
+	episodes := tracker.Episodes(time.Time{})
+	require.Len(t, episodes, 3)
+	require.Equal(t, start.Add(6*time.Minute), episodes[0].Start)
+	require.True(t, episodes[2].Ongoing())
+}
@@ -0,0 +1,191 @@
+package solaxx1rs485
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// YieldPeriod is the length of the periods energy yield is accounted in
+type YieldPeriod int
+
+const (
+	YieldDay YieldPeriod = iota
+	YieldMonth
+	YieldYear
+)
+
+func (p YieldPeriod) String() string {
+	switch p {
+	case YieldDay:
+		return "day"
+	case YieldMonth:
+		return "month"
+	case YieldYear:
+		return "year"
+	}
+	return fmt.Sprintf("YieldPeriod(%d)", int(p))
+}
+
+// ParseYieldPeriod returns the period for day, month or year
+func ParseYieldPeriod(s string) (YieldPeriod, error) {
+	for _, p := range []YieldPeriod{YieldDay, YieldMonth, YieldYear} {
+		if strings.EqualFold(s, p.String()) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid period %q, use day, month or year", s)
+}
+
+// start returns the start of the period containing t, in the location of t
+func (p YieldPeriod) start(t time.Time) time.Time {
+	switch p {
+	case YieldMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case YieldYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// next returns the start of the period after the one starting at start
+func (p YieldPeriod) next(start time.Time) time.Time {
+	switch p {
+	case YieldMonth:
+		return start.AddDate(0, 1, 0)
+	case YieldYear:
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Yield is the energy produced by an inverter in a single period
+type Yield struct {
+	Start     time.Time
+	Address   byte
+	Energy    float64 // kWh
+	Estimated float64 // Part of Energy (kWh) that was interpolated over missed samples spanning multiple periods
+	Samples   int
+}
+
+/*
+YieldAccount accounts the energy yield per inverter per local day, month or year.
+
+The inverter resets EnergyToday at its own midnight, so the yield is computed
+from the EnergyTotal counter instead: the difference between consecutive
+samples is added to the period of the later sample. When the counter decreases
+(e.g. after a reset of the inverter) the new value is taken as the energy produced
+since the reset. When samples were missed and the gap spans multiple periods,
+the difference is spread over these periods proportional to the time in each
+period, and reported as Estimated.
+*/
+type YieldAccount struct {
+	Period   YieldPeriod
+	Location *time.Location // Location the periods are aligned to, defaults to local time
+
+	last   map[byte]yieldReading
+	yields map[yieldKey]*Yield
+}
+
+type yieldReading struct {
+	time   time.Time
+	energy uint32 // 0.1kWh
+}
+
+type yieldKey struct {
+	start   int64
+	address byte
+}
+
+func NewYieldAccount(period YieldPeriod, location *time.Location) *YieldAccount {
+	return &YieldAccount{Period: period, Location: location}
+}
+
+// Add accounts a sample of EnergyTotal (0.1kWh, as in NormalInfoResponse). Samples of an inverter must be added in time order.
+func (a *YieldAccount) Add(t time.Time, address byte, energyTotal uint32) {
+	if a.last == nil {
+		a.last = map[byte]yieldReading{}
+		a.yields = map[yieldKey]*Yield{}
+	}
+	t = t.In(a.location())
+	prev, ok := a.last[address]
+	if ok && t.Before(prev.time) {
+		return // out of order, keep the latest reading
+	}
+	a.bucket(t, address).Samples++
+	a.last[address] = yieldReading{time: t, energy: energyTotal}
+	if !ok {
+		return
+	}
+
+	delta := float64(energyTotal) - float64(prev.energy)
+	if energyTotal < prev.energy {
+		delta = float64(energyTotal) // counter reset
+	}
+	if delta == 0 {
+		return
+	}
+
+	if a.Period.start(prev.time).Equal(a.Period.start(t)) {
+		a.bucket(t, address).Energy += delta / 10
+		return
+	}
+
+	// missed samples, spread the delta over all periods between the samples
+	total := float64(t.Sub(prev.time))
+	from := prev.time
+	for start := a.Period.start(prev.time); start.Before(t); start = a.Period.next(start) {
+		end := a.Period.next(start)
+		if end.After(t) {
+			end = t
+		}
+		portion := delta * float64(end.Sub(from)) / total / 10
+		y := a.bucket(start, address)
+		y.Energy += portion
+		y.Estimated += portion
+		from = end
+	}
+}
+
+// AddRecords accounts all records, ordered by time
+func (a *YieldAccount) AddRecords(records []Record) {
+	sorted := append([]Record{}, records...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	for _, r := range sorted {
+		a.Add(r.Time, r.Inverter.Address, r.Info.EnergyTotal)
+	}
+}
+
+// Yields returns the yield of every period with samples or energy, ordered by period and address
+func (a *YieldAccount) Yields() []Yield {
+	yields := []Yield{}
+	for _, y := range a.yields {
+		yields = append(yields, *y)
+	}
+	sort.Slice(yields, func(i, j int) bool {
+		if !yields[i].Start.Equal(yields[j].Start) {
+			return yields[i].Start.Before(yields[j].Start)
+		}
+		return yields[i].Address < yields[j].Address
+	})
+	return yields
+}
+
+func (a *YieldAccount) bucket(t time.Time, address byte) *Yield {
+	start := a.Period.start(t.In(a.location()))
+	k := yieldKey{start.UnixNano(), address}
+	y, ok := a.yields[k]
+	if !ok {
+		y = &Yield{Start: start, Address: address}
+		a.yields[k] = y
+	}
+	return y
+}
+
+func (a *YieldAccount) location() *time.Location {
+	if a.Location == nil {
+		return time.Local
+	}
+	return a.Location
+}
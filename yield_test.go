@@ -0,0 +1,81 @@
+package solaxx1rs485
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseYieldPeriod(t *testing.T) {
+	p, err := ParseYieldPeriod("Month")
+	require.NoError(t, err)
+	require.Equal(t, YieldMonth, p)
+	_, err = ParseYieldPeriod("week")
+	require.Error(t, err)
+}
+
+func TestYieldAccount(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	at := func(day, hour int) time.Time { return time.Date(2022, 6, day, hour, 0, 0, 0, loc) }
+
+	t.Run("Daily deltas in local time", func(t *testing.T) {
+		a := NewYieldAccount(YieldDay, loc)
+		a.Add(at(1, 6), 1, 1000)
+		a.Add(at(1, 12), 1, 1100)
+		a.Add(at(1, 20), 1, 1150)
+		// 01:00 local is still the previous day in UTC, but the next day locally
+		a.Add(at(2, 1).UTC(), 1, 1150)
+		a.Add(at(2, 12), 1, 1200)
+		a.Add(at(2, 11), 1, 1190) // out of order, ignored
+
+		require.Equal(t, []Yield{
+			{Start: at(1, 0), Address: 1, Energy: 15, Samples: 3},
+			{Start: at(2, 0), Address: 1, Energy: 5, Samples: 2},
+		}, a.Yields())
+	})
+
+	t.Run("Counter reset", func(t *testing.T) {
+		a := NewYieldAccount(YieldDay, loc)
+		a.Add(at(1, 6), 1, 1000)
+		a.Add(at(1, 12), 1, 30)
+		a.Add(at(1, 18), 1, 50)
+		require.Equal(t, []Yield{{Start: at(1, 0), Address: 1, Energy: 5, Samples: 3}}, a.Yields())
+	})
+
+	t.Run("Missed samples spanning days", func(t *testing.T) {
+		a := NewYieldAccount(YieldDay, loc)
+		a.Add(at(1, 12), 1, 1000)
+		// no samples for 48 hours: a quarter of the time is on day 1, half on day 2 and a quarter on day 3
+		a.Add(at(3, 12), 1, 1400)
+
+		yields := a.Yields()
+		require.Len(t, yields, 3)
+		require.Equal(t, at(2, 0), yields[1].Start)
+		require.InDelta(t, 10, yields[0].Energy, 1e-9)
+		require.InDelta(t, 10, yields[0].Estimated, 1e-9)
+		require.InDelta(t, 20, yields[1].Energy, 1e-9)
+		require.Equal(t, 0, yields[1].Samples)
+		require.InDelta(t, 10, yields[2].Energy, 1e-9)
+		require.Equal(t, 1, yields[2].Samples)
+	})
+
+	t.Run("Monthly per inverter", func(t *testing.T) {
+		a := NewYieldAccount(YieldMonth, loc)
+		records := []Record{
+			{Time: at(30, 12), Inverter: Inverter{Address: 2}, Info: NormalInfoResponse{EnergyTotal: 500}},
+			{Time: at(1, 12), Inverter: Inverter{Address: 1}, Info: NormalInfoResponse{EnergyTotal: 1000}},
+			{Time: at(30, 18), Inverter: Inverter{Address: 1}, Info: NormalInfoResponse{EnergyTotal: 3000}},
+			{Time: at(30, 18), Inverter: Inverter{Address: 2}, Info: NormalInfoResponse{EnergyTotal: 510}},
+			{Time: at(31, 0), Inverter: Inverter{Address: 1}, Info: NormalInfoResponse{EnergyTotal: 3000}}, // July 1st
+			{Time: at(31, 12), Inverter: Inverter{Address: 1}, Info: NormalInfoResponse{EnergyTotal: 3100}},
+		}
+		a.AddRecords(records)
+		july := time.Date(2022, 7, 1, 0, 0, 0, 0, loc)
+		require.Equal(t, []Yield{
+			{Start: at(1, 0), Address: 1, Energy: 200, Samples: 2},
+			{Start: at(1, 0), Address: 2, Energy: 1, Samples: 2},
+			{Start: july, Address: 1, Energy: 10, Samples: 2},
+		}, a.Yields())
+	})
+}